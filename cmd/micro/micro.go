@@ -13,6 +13,7 @@ import (
 	"runtime/pprof"
 	"sort"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
@@ -194,14 +195,41 @@ func LoadInput(args []string) []*buffer.Buffer {
 			input = []byte{}
 		}
 		buffers = append(buffers, buffer.NewBufferFromStringAtLoc(string(input), filename, btype, flagStartPos))
+	} else if session := restoreSession(); len(session) > 0 {
+		// Option 3, reopen the buffers from the last session
+		buffers = append(buffers, session...)
 	} else {
-		// Option 3, just open an empty buffer
+		// Option 4, just open an empty buffer
 		buffers = append(buffers, buffer.NewBufferFromStringAtLoc(string(input), filename, btype, flagStartPos))
 	}
 
 	return buffers
 }
 
+// sessionActive is the buffer that was active in the restored session, which
+// is made current once the tabs are created
+var sessionActive *buffer.Buffer
+
+// restoreSession reopens the buffers from the last session, in their saved
+// order, if the restoresession option is enabled
+func restoreSession() []*buffer.Buffer {
+	if !config.GetGlobalOption("restoresession").(bool) {
+		return nil
+	}
+	bufs, active, missing, err := buffer.ReadSession()
+	if err != nil {
+		screen.TermMessage(err)
+		return nil
+	}
+	if len(missing) > 0 {
+		action.InfoBar.Message("Skipped missing files: ", strings.Join(missing, ", "))
+	}
+	if len(bufs) > 0 {
+		sessionActive = bufs[active]
+	}
+	return bufs
+}
+
 func checkBackup(name string) error {
 	target := filepath.Join(config.ConfigDir, name)
 	backup := util.AppendBackupSuffix(target)
@@ -232,6 +260,7 @@ func checkBackup(name string) error {
 }
 
 func exit(rc int) {
+	action.SaveSession()
 	for _, b := range buffer.OpenBuffers {
 		if !b.Modified() {
 			b.Fini()
@@ -239,6 +268,7 @@ func exit(rc int) {
 	}
 
 	if screen.Screen != nil {
+		screen.RestoreTitle()
 		screen.Screen.Fini()
 	}
 
@@ -293,7 +323,10 @@ func main() {
 		screen.TermMessage(err)
 	}
 
-	config.InitRuntimeFiles(true)
+	err = config.InitRuntimeFiles(true)
+	if err != nil {
+		screen.TermMessage(err)
+	}
 
 	err = checkBackup("settings.json")
 	if err != nil {
@@ -373,6 +406,9 @@ func main() {
 	}
 
 	action.InitTabs(b)
+	if sessionActive != nil {
+		action.ActivateBuffer(sessionActive)
+	}
 
 	err = config.InitColorscheme()
 	if err != nil {
@@ -442,6 +478,7 @@ func DoEvent() {
 	action.MainTab().Display()
 	action.InfoBar.Display()
 	screen.Screen.Show()
+	action.UpdateTitle()
 
 	// Check for new events
 	select {
@@ -1894,12 +1894,16 @@ func (h *BufPane) ClearInfo() bool {
 // ForceQuit closes the tab or view even if there are unsaved changes
 // (no prompt)
 func (h *BufPane) ForceQuit() bool {
+	if len(h.tab.Panes) <= 1 && len(Tabs.List) <= 1 {
+		SaveSession()
+	}
 	h.Buf.Close()
 	if len(h.tab.Panes) > 1 {
 		h.Unsplit()
 	} else if len(Tabs.List) > 1 {
 		Tabs.RemoveTab(h.splitID)
 	} else {
+		screen.RestoreTitle()
 		screen.Screen.Fini()
 		InfoBar.Close()
 		runtime.Goexit()
@@ -1949,7 +1953,9 @@ func (h *BufPane) QuitAll() bool {
 	}
 
 	quit := func() {
+		SaveSession()
 		buffer.CloseOpenBuffers()
+		screen.RestoreTitle()
 		screen.Screen.Fini()
 		InfoBar.Close()
 		runtime.Goexit()
@@ -274,6 +274,9 @@ func (h *BufPane) OpenBuffer(b *buffer.Buffer) {
 	h.Buf.Close()
 	h.Buf = b
 	h.BWindow.SetBuffer(b)
+	if h.IsActive() {
+		b.Activate()
+	}
 	h.Cursor = b.GetActiveCursor()
 	h.Resize(h.GetView().Width, h.GetView().Height)
 	h.initialRelocate()
@@ -339,10 +342,26 @@ func (h *BufPane) Name() string {
 	return n
 }
 
-// ReOpen reloads the file opened in the bufpane from disk
+// ReOpen reloads the file opened in the bufpane from disk, keeping
+// the undo history
 func (h *BufPane) ReOpen() {
-	h.Buf.ReOpen()
+	if err := h.Buf.Reload(); err != nil {
+		InfoBar.Error(err)
+		return
+	}
 	h.Relocate()
+	InfoBar.Message("Reloaded " + h.Buf.GetName() + " (undo history kept)")
+}
+
+// HardReOpen reloads the file opened in the bufpane from disk,
+// discarding the undo history
+func (h *BufPane) HardReOpen() {
+	if err := h.Buf.HardReload(); err != nil {
+		InfoBar.Error(err)
+		return
+	}
+	h.Relocate()
+	InfoBar.Message("Reloaded " + h.Buf.GetName() + " (undo history discarded)")
 }
 
 func (h *BufPane) getReloadSetting() string {
@@ -618,6 +637,9 @@ func (h *BufPane) Close() {
 
 // SetActive marks this pane as active.
 func (h *BufPane) SetActive(b bool) {
+	if b {
+		h.Buf.Activate()
+	}
 	if h.IsActive() == b {
 		return
 	}
@@ -33,39 +33,47 @@ var commands map[string]Command
 
 func InitCommands() {
 	commands = map[string]Command{
-		"set":        {(*BufPane).SetCmd, OptionValueComplete},
-		"reset":      {(*BufPane).ResetCmd, OptionValueComplete},
-		"setlocal":   {(*BufPane).SetLocalCmd, OptionValueComplete},
-		"show":       {(*BufPane).ShowCmd, OptionComplete},
-		"showkey":    {(*BufPane).ShowKeyCmd, nil},
-		"run":        {(*BufPane).RunCmd, nil},
-		"bind":       {(*BufPane).BindCmd, nil},
-		"unbind":     {(*BufPane).UnbindCmd, nil},
-		"quit":       {(*BufPane).QuitCmd, nil},
-		"goto":       {(*BufPane).GotoCmd, nil},
-		"jump":       {(*BufPane).JumpCmd, nil},
-		"save":       {(*BufPane).SaveCmd, nil},
-		"replace":    {(*BufPane).ReplaceCmd, nil},
-		"replaceall": {(*BufPane).ReplaceAllCmd, nil},
-		"vsplit":     {(*BufPane).VSplitCmd, buffer.FileComplete},
-		"hsplit":     {(*BufPane).HSplitCmd, buffer.FileComplete},
-		"tab":        {(*BufPane).NewTabCmd, buffer.FileComplete},
-		"help":       {(*BufPane).HelpCmd, HelpComplete},
-		"eval":       {(*BufPane).EvalCmd, nil},
-		"log":        {(*BufPane).ToggleLogCmd, nil},
-		"plugin":     {(*BufPane).PluginCmd, PluginComplete},
-		"reload":     {(*BufPane).ReloadCmd, nil},
-		"reopen":     {(*BufPane).ReopenCmd, nil},
-		"cd":         {(*BufPane).CdCmd, buffer.FileComplete},
-		"pwd":        {(*BufPane).PwdCmd, nil},
-		"open":       {(*BufPane).OpenCmd, buffer.FileComplete},
-		"tabmove":    {(*BufPane).TabMoveCmd, nil},
-		"tabswitch":  {(*BufPane).TabSwitchCmd, nil},
-		"term":       {(*BufPane).TermCmd, nil},
-		"memusage":   {(*BufPane).MemUsageCmd, nil},
-		"retab":      {(*BufPane).RetabCmd, nil},
-		"raw":        {(*BufPane).RawCmd, nil},
-		"textfilter": {(*BufPane).TextFilterCmd, nil},
+		"set":            {(*BufPane).SetCmd, OptionValueComplete},
+		"reset":          {(*BufPane).ResetCmd, OptionValueComplete},
+		"setlocal":       {(*BufPane).SetLocalCmd, OptionValueComplete},
+		"show":           {(*BufPane).ShowCmd, OptionComplete},
+		"showkey":        {(*BufPane).ShowKeyCmd, nil},
+		"run":            {(*BufPane).RunCmd, nil},
+		"bind":           {(*BufPane).BindCmd, nil},
+		"unbind":         {(*BufPane).UnbindCmd, nil},
+		"quit":           {(*BufPane).QuitCmd, nil},
+		"goto":           {(*BufPane).GotoCmd, nil},
+		"jump":           {(*BufPane).JumpCmd, nil},
+		"save":           {(*BufPane).SaveCmd, nil},
+		"replace":        {(*BufPane).ReplaceCmd, nil},
+		"replaceall":     {(*BufPane).ReplaceAllCmd, nil},
+		"vsplit":         {(*BufPane).VSplitCmd, buffer.FileComplete},
+		"hsplit":         {(*BufPane).HSplitCmd, buffer.FileComplete},
+		"tab":            {(*BufPane).NewTabCmd, buffer.FileComplete},
+		"help":           {(*BufPane).HelpCmd, HelpComplete},
+		"eval":           {(*BufPane).EvalCmd, nil},
+		"log":            {(*BufPane).ToggleLogCmd, nil},
+		"plugin":         {(*BufPane).PluginCmd, PluginComplete},
+		"reload":         {(*BufPane).ReloadCmd, nil},
+		"reopen":         {(*BufPane).ReopenCmd, nil},
+		"hardreload":     {(*BufPane).HardReloadCmd, nil},
+		"cd":             {(*BufPane).CdCmd, buffer.FileComplete},
+		"pwd":            {(*BufPane).PwdCmd, nil},
+		"open":           {(*BufPane).OpenCmd, buffer.FileComplete},
+		"tabmove":        {(*BufPane).TabMoveCmd, nil},
+		"tabswitch":      {(*BufPane).TabSwitchCmd, nil},
+		"lastbuffer":     {(*BufPane).LastBufferCmd, nil},
+		"term":           {(*BufPane).TermCmd, nil},
+		"memusage":       {(*BufPane).MemUsageCmd, nil},
+		"retab":          {(*BufPane).RetabCmd, nil},
+		"retabselection": {(*BufPane).RetabSelectionCmd, nil},
+		"checkindent":    {(*BufPane).CheckIndentCmd, nil},
+		"fixindent":      {(*BufPane).FixIndentCmd, nil},
+		"dupcomment":     {(*BufPane).DupCommentCmd, nil},
+		"trimwidth":      {(*BufPane).TrimWidthCmd, nil},
+		"raw":            {(*BufPane).RawCmd, nil},
+		"textfilter":     {(*BufPane).TextFilterCmd, nil},
+		"pipe":           {(*BufPane).PipeCmd, nil},
 	}
 }
 
@@ -122,6 +130,121 @@ func (h *BufPane) RetabCmd(args []string) {
 	h.Buf.Retab()
 }
 
+// RetabSelectionCmd changes the leading tabs to spaces or vice versa in the
+// selected lines only, depending on the user's settings
+func (h *BufPane) RetabSelectionCmd(args []string) {
+	if !h.Cursor.HasSelection() {
+		InfoBar.Error("No selection")
+		return
+	}
+	start, end := h.Cursor.CurSelection[0], h.Cursor.CurSelection[1]
+	if end.LessThan(start) {
+		start, end = end, start
+	}
+	if end.X == 0 && end.Y > start.Y {
+		end.Y--
+	}
+	h.Buf.RetabLines(start.Y, end.Y)
+	h.Relocate()
+}
+
+// CheckIndentCmd lists the lines whose indentation style differs from
+// the rest of the buffer in a scratch buffer
+func (h *BufPane) CheckIndentCmd(args []string) {
+	lines := h.Buf.MixedIndentLines()
+	if len(lines) == 0 {
+		InfoBar.Message("No mixed indentation found")
+		return
+	}
+
+	style := "spaces"
+	if tabs, _ := h.Buf.DetectIndent(); tabs {
+		style = "tabs"
+	}
+
+	var report strings.Builder
+	fmt.Fprintf(&report, "%s is indented with %s, %d line(s) differ:\n\n", h.Buf.GetName(), style, len(lines))
+	for _, l := range lines {
+		fmt.Fprintf(&report, "%d: %s\n", l+1, h.Buf.Line(l))
+	}
+
+	buf := buffer.NewBufferFromString(report.String(), "", buffer.BTScratch)
+	buf.SetName("Mixed indentation")
+	h.HSplitBuf(buf)
+}
+
+// FixIndentCmd changes the indentation of the lines whose indentation
+// style differs from the rest of the buffer to match it
+func (h *BufPane) FixIndentCmd(args []string) {
+	n := h.Buf.FixIndent()
+	if n == 0 {
+		InfoBar.Message("No mixed indentation found")
+		return
+	}
+	h.Relocate()
+	InfoBar.Message(fmt.Sprintf("Fixed indentation of %d line(s)", n))
+}
+
+// DupCommentCmd duplicates the current line and comments out the original,
+// leaving the cursor on the uncommented copy
+func (h *BufPane) DupCommentCmd(args []string) {
+	if h.Buf.Type.Readonly {
+		InfoBar.Error("Cannot edit a readonly buffer")
+		return
+	}
+	h.Cursor.Deselect(true)
+	if err := h.Buf.DupComment(h.Cursor.Y); err != nil {
+		InfoBar.Error(err)
+		return
+	}
+	h.Relocate()
+}
+
+// TrimWidthCmd truncates the selected lines, or the current line if there
+// is no selection, to a maximum number of columns. With the -wrap flag the
+// overflow is moved to new lines instead
+func (h *BufPane) TrimWidthCmd(args []string) {
+	wrap := false
+	var nums []string
+	for _, a := range args {
+		if a == "-wrap" {
+			wrap = true
+		} else {
+			nums = append(nums, a)
+		}
+	}
+	if len(nums) != 1 {
+		InfoBar.Error("usage: trimwidth [-wrap] columns")
+		return
+	}
+	width, err := strconv.Atoi(nums[0])
+	if err != nil || width <= 0 {
+		InfoBar.Error("Invalid width: ", nums[0])
+		return
+	}
+	if h.Buf.Type.Readonly {
+		InfoBar.Error("Cannot edit a readonly buffer")
+		return
+	}
+
+	start, end := h.Cursor.Y, h.Cursor.Y
+	if h.Cursor.HasSelection() {
+		s, e := h.Cursor.CurSelection[0], h.Cursor.CurSelection[1]
+		if e.LessThan(s) {
+			s, e = e, s
+		}
+		if e.X == 0 && e.Y > s.Y {
+			e.Y--
+		}
+		start, end = s.Y, e.Y
+	}
+
+	h.Cursor.Deselect(true)
+	n := h.Buf.TrimLines(start, end, width, wrap)
+	h.Relocate()
+	InfoBar.Message(fmt.Sprintf("Trimmed %d line(s)", n))
+}
+
 // RawCmd opens a new raw view which displays the escape sequences micro
 // is receiving in real-time
 func (h *BufPane) RawCmd(args []string) {
@@ -161,6 +284,37 @@ func (h *BufPane) TextFilterCmd(args []string) {
 	}
 }
 
+// PipeCmd pipes the selection, or the whole buffer if nothing is
+// selected, through the command and replaces it with the command's output
+func (h *BufPane) PipeCmd(args []string) {
+	if len(args) == 0 {
+		InfoBar.Error("usage: pipe command [arguments]")
+		return
+	}
+	if h.Buf.Type.Readonly {
+		InfoBar.Error("Cannot pipe a readonly buffer")
+		return
+	}
+
+	c := h.Cursor
+	start, end := h.Buf.Start(), h.Buf.End()
+	if c.HasSelection() {
+		start, end = c.CurSelection[0], c.CurSelection[1]
+		if end.LessThan(start) {
+			start, end = end, start
+		}
+	}
+
+	out, err := shell.PipeCommand(bytes.NewReader(h.Buf.Substr(start, end)), args[0], args[1:]...)
+	if err != nil {
+		InfoBar.Error(err)
+		return
+	}
+	c.ResetSelection()
+	h.Buf.Replace(start, end, out)
+	h.Relocate()
+}
+
 // TabMoveCmd moves the current tab to a given index (starts at 1). The
 // displaced tabs are moved up.
 func (h *BufPane) TabMoveCmd(args []string) {
@@ -240,6 +394,28 @@ func (h *BufPane) TabSwitchCmd(args []string) {
 	}
 }
 
+// LastBufferCmd switches to the most recently used buffer before the
+// current one, so that it toggles between the two most recent buffers
+func (h *BufPane) LastBufferCmd(args []string) {
+	recent := buffer.RecentBuffers()
+	if len(recent) < 2 {
+		InfoBar.Error("No previous buffer")
+		return
+	}
+	target := recent[1]
+
+	for i, t := range Tabs.List {
+		for j, p := range t.Panes {
+			if bp, ok := p.(*BufPane); ok && bp.Buf == target {
+				Tabs.SetActive(i)
+				t.SetActive(j)
+				return
+			}
+		}
+	}
+	InfoBar.Error("Buffer ", target.GetName(), " is not displayed")
+}
+
 // CdCmd changes the current working directory
 func (h *BufPane) CdCmd(args []string) {
 	if len(args) > 0 {
@@ -361,13 +537,16 @@ func reloadRuntime(reloadPlugins bool) {
 		}
 	}
 
-	config.InitRuntimeFiles(true)
+	err := config.InitRuntimeFiles(true)
+	if err != nil {
+		screen.TermMessage(err)
+	}
 
 	if reloadPlugins {
 		config.InitPlugins()
 	}
 
-	err := config.ReadSettings()
+	err = config.ReadSettings()
 	if err != nil {
 		screen.TermMessage(err)
 	} else {
@@ -440,6 +619,22 @@ func (h *BufPane) ReopenCmd(args []string) {
 	}
 }
 
+// HardReloadCmd reloads the buffer from disk, discarding the undo history
+func (h *BufPane) HardReloadCmd(args []string) {
+	if h.Buf.Modified() {
+		InfoBar.YNPrompt("Save file before reload?", func(yes, canceled bool) {
+			if !canceled && yes {
+				h.Save()
+				h.HardReOpen()
+			} else if !canceled {
+				h.HardReOpen()
+			}
+		})
+	} else {
+		h.HardReOpen()
+	}
+}
+
 func (h *BufPane) openHelp(page string, hsplit bool, forceSplit bool) error {
 	if data, err := config.FindRuntimeFile(config.RTHelp, page).Data(); err != nil {
 		return errors.New(fmt.Sprintf("Unable to load help text for %s: %v", page, err))
@@ -596,7 +791,17 @@ func doSetGlobalOptionNative(option string, nativeValue interface{}) error {
 
 	if option == "colorscheme" {
 		// LoadSyntaxFiles()
-		config.InitColorscheme()
+		if err := config.InitColorscheme(); err != nil {
+			InfoBar.Error(err)
+		}
+		for _, b := range buffer.OpenBuffers {
+			b.UpdateRules()
+		}
+	} else if option == "truecolor" {
+		config.ClearColorCache()
+		if err := config.InitColorscheme(); err != nil {
+			InfoBar.Error(err)
+		}
 		for _, b := range buffer.OpenBuffers {
 			b.UpdateRules()
 		}
@@ -616,6 +821,12 @@ func doSetGlobalOptionNative(option string, nativeValue interface{}) error {
 		}
 	} else if option == "paste" {
 		screen.Screen.SetPaste(nativeValue.(bool))
+	} else if option == "settitle" {
+		if !nativeValue.(bool) {
+			screen.RestoreTitle()
+		}
+	} else if option == "showcontrol" {
+		util.ShowControl = nativeValue.(bool)
 	} else if option == "clipboard" {
 		m := clipboard.SetMethod(nativeValue.(string))
 		err := clipboard.Initialize(m)
@@ -906,7 +1117,7 @@ func (h *BufPane) SaveCmd(args []string) {
 
 // ReplaceCmd runs search and replace
 func (h *BufPane) ReplaceCmd(args []string) {
-	if len(args) < 2 || len(args) > 4 {
+	if len(args) < 2 || len(args) > 5 {
 		// We need to find both a search and replace expression
 		InfoBar.Error("Invalid replace statement: " + strings.Join(args, " "))
 		return
@@ -914,6 +1125,7 @@ func (h *BufPane) ReplaceCmd(args []string) {
 
 	all := false
 	noRegex := false
+	count := false
 
 	foundSearch := false
 	foundReplace := false
@@ -925,6 +1137,8 @@ func (h *BufPane) ReplaceCmd(args []string) {
 			all = true
 		case "-l":
 			noRegex = true
+		case "-c":
+			count = true
 		default:
 			if !foundSearch {
 				foundSearch = true
@@ -968,7 +1182,18 @@ func (h *BufPane) ReplaceCmd(args []string) {
 		end = h.Cursor.CurSelection[1]
 		searchLoc = start // otherwise me might start at the end
 	}
-	if all {
+	if count {
+		n := h.Buf.CountRegex(start, end, regex)
+		s := fmt.Sprintf("Found %d occurrences of %s", n, search)
+		if n == 1 {
+			s = fmt.Sprintf("Found 1 occurrence of %s", search)
+		}
+		if selection {
+			s += " in selection"
+		}
+		InfoBar.Message(s)
+		return
+	} else if all {
 		nreplaced, _ = h.Buf.ReplaceRegex(start, end, regex, replace, !noRegex)
 	} else {
 		inRange := func(l buffer.Loc) bool {
@@ -1,6 +1,12 @@
 package action
 
-import "github.com/zyedidia/micro/v2/internal/buffer"
+import (
+	"log"
+
+	"github.com/zyedidia/micro/v2/internal/buffer"
+	"github.com/zyedidia/micro/v2/internal/config"
+	"github.com/zyedidia/micro/v2/internal/screen"
+)
 
 // InfoBar is the global info bar.
 var InfoBar *InfoPane
@@ -35,3 +41,45 @@ func (h *BufPane) OpenLogBuf() {
 	LogBufPane = h.HSplitBuf(buffer.LogBuf)
 	LogBufPane.CursorEnd()
 }
+
+// UpdateTitle sets the terminal title to the name of the current buffer
+// (with a modified indicator) if the settitle option is enabled
+func UpdateTitle() {
+	if !config.GetGlobalOption("settitle").(bool) {
+		return
+	}
+	if h := MainTab().CurPane(); h != nil {
+		screen.SetTitle(h.Name() + " - micro")
+	}
+}
+
+// SaveSession saves the open buffers so that they can be reopened the next
+// time micro starts, if the restoresession option is enabled
+func SaveSession() {
+	if !config.GetGlobalOption("restoresession").(bool) || Tabs == nil {
+		return
+	}
+	var active *buffer.Buffer
+	if h := MainTab().CurPane(); h != nil {
+		active = h.Buf
+	}
+	if err := buffer.WriteSession(tabBuffers(), active); err != nil {
+		log.Println("Error saving session:", err)
+	}
+}
+
+// tabBuffers returns the buffers shown in the tabs, in tab and pane order,
+// listing each buffer once
+func tabBuffers() []*buffer.Buffer {
+	var bufs []*buffer.Buffer
+	seen := make(map[*buffer.Buffer]bool)
+	for _, t := range Tabs.List {
+		for _, p := range t.Panes {
+			if bp, ok := p.(*BufPane); ok && !seen[bp.Buf] {
+				seen[bp.Buf] = true
+				bufs = append(bufs, bp.Buf)
+			}
+		}
+	}
+	return bufs
+}
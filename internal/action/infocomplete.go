@@ -61,8 +61,18 @@ func HelpComplete(b *buffer.Buffer) ([]string, []string) {
 // colorschemeComplete tab-completes names of colorschemes.
 // In micromini, colorschemes are hardcoded, so no completions available.
 func colorschemeComplete(input string) (string, []string) {
-	// No colorscheme files available in micromini - using hardcoded dark theme
 	var suggestions []string
+	for _, name := range []string{"default", "default-dark", "default-light", "high-contrast"} {
+		if strings.HasPrefix(name, input) {
+			suggestions = append(suggestions, name)
+		}
+	}
+	for _, f := range config.ListRuntimeFiles(config.RTColorscheme) {
+		if strings.HasPrefix(f.Name(), input) {
+			suggestions = append(suggestions, f.Name())
+		}
+	}
+
 	var chosen string
 	if len(suggestions) == 1 {
 		chosen = suggestions[0]
@@ -154,6 +154,9 @@ func (t *TabList) SetActive(a int) {
 			if !p.isActive {
 				p.isActive = true
 			}
+			if h := p.CurPane(); h != nil {
+				h.Buf.Activate()
+			}
 		} else {
 			p.isActive = false
 		}
@@ -209,9 +212,28 @@ func InitTabs(bufs []*buffer.Buffer) {
 		}
 	}
 
+	if h := MainTab().CurPane(); h != nil {
+		h.Buf.Activate()
+	}
+
 	screen.RestartCallback = Tabs.ResetMouse
 }
 
+// ActivateBuffer makes the first pane showing b the current one, switching
+// to its tab. It returns false if no pane shows b
+func ActivateBuffer(b *buffer.Buffer) bool {
+	for i, t := range Tabs.List {
+		for j, p := range t.Panes {
+			if bp, ok := p.(*BufPane); ok && bp.Buf == b {
+				t.SetActive(j)
+				Tabs.SetActive(i)
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func MainTab() *Tab {
 	return Tabs.List[Tabs.Active()]
 }
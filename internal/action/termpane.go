@@ -103,6 +103,7 @@ func (t *TermPane) Quit() {
 	} else if len(Tabs.List) > 1 {
 		Tabs.RemoveTab(t.id)
 	} else {
+		screen.RestoreTitle()
 		screen.Screen.Fini()
 		InfoBar.Close()
 		runtime.Goexit()
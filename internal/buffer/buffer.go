@@ -15,6 +15,7 @@ import (
 	"sync"
 	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	dmp "github.com/sergi/go-diff/diffmatchpatch"
 	"github.com/zyedidia/micro/v2/internal/config"
@@ -33,6 +34,10 @@ var (
 	// LogBuf is a reference to the log buffer which can be opened with the
 	// `> log` command
 	LogBuf *Buffer
+
+	// recentBuffers holds the open buffers that have been activated, most
+	// recently activated first
+	recentBuffers []*Buffer
 )
 
 // The BufType defines what kind of buffer this is
@@ -118,6 +123,13 @@ type SharedBuffer struct {
 
 	ModifiedThisFrame bool
 
+	// Number of edits made to the buffer, used to invalidate caches
+	edits int
+
+	// Cached number of words in the buffer, valid until the next edit
+	wordCount      int
+	wordCountValid bool
+
 	// Hash of the original buffer -- empty if fastdirty is on
 	origHash [md5.Size]byte
 }
@@ -125,6 +137,8 @@ type SharedBuffer struct {
 func (b *SharedBuffer) insert(pos Loc, value []byte) {
 	b.isModified = true
 	b.HasSuggestions = false
+	b.wordCountValid = false
+	b.edits++
 	b.LineArray.insert(pos, value)
 
 	inslines := bytes.Count(value, []byte{'\n'})
@@ -133,6 +147,8 @@ func (b *SharedBuffer) insert(pos Loc, value []byte) {
 func (b *SharedBuffer) remove(start, end Loc) []byte {
 	b.isModified = true
 	b.HasSuggestions = false
+	b.wordCountValid = false
+	b.edits++
 	defer b.MarkModified(start.Y, end.Y)
 	return b.LineArray.remove(start, end)
 }
@@ -209,6 +225,10 @@ type Buffer struct {
 	LastSearchRegex bool
 	// HighlightSearch enables highlighting all instances of the last successful search
 	HighlightSearch bool
+	// cached matches of the last search, see SearchMatches
+	searchCache searchCache
+	// cached diff against the file on disk, see DiffAgainstSaved
+	savedDiffCache savedDiffCache
 
 	// OverwriteMode indicates that we are in overwrite mode (toggled by
 	// Insert key by default) i.e. that typing a character shall replace the
@@ -352,7 +372,14 @@ func NewBuffer(r io.Reader, size int64, path string, startcursor Loc, btype BufT
 			return NewBufferFromString("", "", btype)
 		}
 		if !hasBackup {
-			reader := bufio.NewReader(transform.NewReader(r, b.encoding.NewDecoder()))
+			br := bufio.NewReader(r)
+			if b.Settings["encoding"] == "utf-8" {
+				if name, enc := detectBOM(br); enc != nil {
+					b.encoding = enc
+					b.Settings["encoding"] = name
+				}
+			}
+			reader := bufio.NewReader(transform.NewReader(br, b.encoding.NewDecoder()))
 
 			var ff FileFormat = FFAuto
 
@@ -432,10 +459,34 @@ func CloseOpenBuffers() {
 		OpenBuffers[i] = nil
 	}
 	OpenBuffers = OpenBuffers[:0]
+	recentBuffers = nil
+}
+
+func (b *Buffer) removeRecent() {
+	for i, buf := range recentBuffers {
+		if buf == b {
+			recentBuffers = append(recentBuffers[:i], recentBuffers[i+1:]...)
+			return
+		}
+	}
+}
+
+// Activate marks the buffer as the most recently used one. It should be
+// called whenever the buffer becomes the active buffer
+func (b *Buffer) Activate() {
+	b.removeRecent()
+	recentBuffers = append([]*Buffer{b}, recentBuffers...)
+}
+
+// RecentBuffers returns the open buffers that have been activated, ordered
+// from the most to the least recently used
+func RecentBuffers() []*Buffer {
+	return recentBuffers
 }
 
 // Close removes this buffer from the list of open buffers
 func (b *Buffer) Close() {
+	b.removeRecent()
 	for i, buf := range OpenBuffers {
 		if b == buf {
 			b.Fini()
@@ -505,11 +556,126 @@ func (b *Buffer) Remove(start, end Loc) {
 	}
 }
 
+// Replace replaces the characters between the start and end locations
+// with the given text
+func (b *Buffer) Replace(start, end Loc, text string) {
+	if !b.Type.Readonly {
+		b.EventHandler.cursors = b.cursors
+		b.EventHandler.active = b.curCursor
+		b.EventHandler.Replace(start, end, text)
+
+		b.RequestBackup()
+	}
+}
+
+// ApplyEdits applies a list of non-overlapping edits as a single undoable
+// event, see EventHandler.ApplyEdits
+func (b *Buffer) ApplyEdits(edits []Edit) error {
+	if b.Type.Readonly {
+		return errors.New("Cannot edit a readonly buffer")
+	}
+	b.EventHandler.cursors = b.cursors
+	b.EventHandler.active = b.curCursor
+	if err := b.EventHandler.ApplyEdits(edits); err != nil {
+		return err
+	}
+
+	b.RequestBackup()
+	return nil
+}
+
 // FileType returns the buffer's filetype
 func (b *Buffer) FileType() string {
 	return b.Settings["filetype"].(string)
 }
 
+// commentTypes maps filetypes to their default line comment format, where
+// %s stands for the commented text. The commenttype option overrides these
+var commentTypes = map[string]string{
+	"c":          "// %s",
+	"c++":        "// %s",
+	"csharp":     "// %s",
+	"css":        "/* %s */",
+	"d":          "// %s",
+	"dart":       "// %s",
+	"dockerfile": "# %s",
+	"elixir":     "# %s",
+	"erlang":     "% %s",
+	"fish":       "# %s",
+	"go":         "// %s",
+	"haskell":    "-- %s",
+	"html":       "<!-- %s -->",
+	"ini":        "; %s",
+	"java":       "// %s",
+	"javascript": "// %s",
+	"jsonc":      "// %s",
+	"julia":      "# %s",
+	"kotlin":     "// %s",
+	"latex":      "% %s",
+	"lisp":       "; %s",
+	"lua":        "-- %s",
+	"makefile":   "# %s",
+	"markdown":   "<!-- %s -->",
+	"nim":        "# %s",
+	"perl":       "# %s",
+	"php":        "// %s",
+	"powershell": "# %s",
+	"python":     "# %s",
+	"python2":    "# %s",
+	"r":          "# %s",
+	"ruby":       "# %s",
+	"rust":       "// %s",
+	"scala":      "// %s",
+	"shell":      "# %s",
+	"sql":        "-- %s",
+	"swift":      "// %s",
+	"tcl":        "# %s",
+	"tex":        "% %s",
+	"toml":       "# %s",
+	"typescript": "// %s",
+	"vim":        "\" %s",
+	"xml":        "<!-- %s -->",
+	"yaml":       "# %s",
+	"zig":        "// %s",
+	"zsh":        "# %s",
+}
+
+// CommentType returns the line comment format for the buffer, where %s
+// stands for the commented text. The commenttype option takes precedence
+// over the filetype's default, and an error is returned if neither is known
+func (b *Buffer) CommentType() (string, error) {
+	if ct, ok := b.Settings["commenttype"].(string); ok && ct != "" {
+		return ct, nil
+	}
+	if ct, ok := commentTypes[b.FileType()]; ok {
+		return ct, nil
+	}
+	return "", fmt.Errorf("No comment type for filetype %s, set the commenttype option", b.FileType())
+}
+
+// CommentLine returns line y with its content (after the leading
+// whitespace) commented out
+func (b *Buffer) CommentLine(y int) (string, error) {
+	ct, err := b.CommentType()
+	if err != nil {
+		return "", err
+	}
+	l := b.LineBytes(y)
+	ws := util.GetLeadingWhitespace(l)
+	return string(ws) + fmt.Sprintf(ct, string(l[len(ws):])), nil
+}
+
+// DupComment duplicates line y and comments out the first copy, as a
+// single undoable event. Cursors on the line stay on the uncommented copy
+func (b *Buffer) DupComment(y int) error {
+	line, err := b.CommentLine(y)
+	if err != nil {
+		return err
+	}
+	b.Insert(Loc{0, y}, line+"\n")
+	return nil
+}
+
 // ExternallyModified returns whether the file being edited has
 // been modified by some external process
 func (b *Buffer) ExternallyModified() bool {
@@ -520,35 +686,98 @@ func (b *Buffer) ExternallyModified() bool {
 	return false
 }
 
+// detectBOM looks for a byte order mark at the start of r without consuming
+// it and returns the name and encoding it indicates, or a nil encoding if
+// there is none. The returned encodings strip the mark when decoding and
+// write it back when encoding
+func detectBOM(r *bufio.Reader) (string, encoding.Encoding) {
+	bom, _ := r.Peek(3)
+	switch {
+	case bytes.HasPrefix(bom, []byte{0xEF, 0xBB, 0xBF}):
+		return "utf-8", unicode.UTF8BOM
+	case bytes.HasPrefix(bom, []byte{0xFF, 0xFE}):
+		return "utf-16le", unicode.UTF16(unicode.LittleEndian, unicode.ExpectBOM)
+	case bytes.HasPrefix(bom, []byte{0xFE, 0xFF}):
+		return "utf-16be", unicode.UTF16(unicode.BigEndian, unicode.ExpectBOM)
+	}
+	return "", nil
+}
+
+// WordCount returns the number of whitespace-delimited words in the buffer.
+// The count is cached until the buffer is next modified
+func (b *SharedBuffer) WordCount() int {
+	if b.wordCountValid {
+		return b.wordCount
+	}
+
+	n := 0
+	for i := range b.lines {
+		inWord := false
+		for _, r := range string(b.lines[i].data) {
+			if util.IsWhitespace(r) {
+				inWord = false
+			} else if !inWord {
+				inWord = true
+				n++
+			}
+		}
+	}
+	b.wordCount = n
+	b.wordCountValid = true
+	return n
+}
+
+// Encoding returns the name of the encoding of the buffer
+func (b *Buffer) Encoding() string {
+	return b.Settings["encoding"].(string)
+}
+
 // UpdateModTime updates the modtime of this file
 func (b *Buffer) UpdateModTime() (err error) {
 	b.ModTime, err = util.GetModTime(b.Path)
 	return
 }
 
-// ReOpen reloads the current buffer from disk
-func (b *Buffer) ReOpen() error {
+// readFromDisk reads the current contents of the buffer's file from disk,
+// decoded with the buffer's encoding
+func (b *Buffer) readFromDisk() ([]byte, error) {
 	file, err := os.Open(b.Path)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer file.Close()
 
-	enc, err := htmlindex.Get(b.Settings["encoding"].(string))
+	reader := bufio.NewReader(transform.NewReader(file, b.encoding.NewDecoder()))
+	return io.ReadAll(reader)
+}
+
+// Reload reloads the current buffer from disk. The new contents are
+// applied as a diff so that the undo history is kept and the reload
+// itself can be undone
+func (b *Buffer) Reload() error {
+	data, err := b.readFromDisk()
 	if err != nil {
 		return err
 	}
+	b.EventHandler.ApplyDiff(string(data))
 
-	reader := bufio.NewReader(transform.NewReader(file, enc.NewDecoder()))
-	data, err := io.ReadAll(reader)
-	txt := string(data)
+	return b.finishReload(data)
+}
 
+// HardReload reloads the current buffer from disk, replacing its contents
+// and discarding the undo and redo history
+func (b *Buffer) HardReload() error {
+	data, err := b.readFromDisk()
 	if err != nil {
 		return err
 	}
-	b.EventHandler.ApplyDiff(txt)
+	b.SetContent(string(data))
+
+	return b.finishReload(data)
+}
 
-	err = b.UpdateModTime()
+func (b *Buffer) finishReload(data []byte) error {
+	err := b.UpdateModTime()
 	if !b.Settings["fastdirty"].(bool) {
 		if len(data) > LargeFileThreshold {
 			b.Settings["fastdirty"] = true
@@ -561,6 +790,25 @@ func (b *Buffer) ReOpen() error {
 	return err
 }
 
+// SetContent replaces the whole content of the buffer with the given text.
+// Unlike ApplyDiff this is not recorded as an event: the undo and redo
+// history is discarded
+func (b *Buffer) SetContent(txt string) {
+	b.LineArray = NewLineArray(uint64(len(txt)), b.Endings, strings.NewReader(txt))
+	b.clearHistory()
+	b.isModified = true
+	b.HasSuggestions = false
+	b.wordCountValid = false
+	b.edits++
+	b.ModifiedThisFrame = true
+
+	if b.Settings["syntax"].(bool) && b.SyntaxDef != nil && b.Highlighter != nil {
+		b.Highlighter.HighlightStates(b)
+		b.Highlighter.HighlightMatches(b, 0, b.End().Y)
+	}
+	b.RelocateCursors()
+}
+
 // RelocateCursors relocates all cursors (makes sure they are in the buffer)
 func (b *Buffer) RelocateCursors() {
 	for _, c := range b.cursors {
@@ -1083,8 +1331,8 @@ func (b *Buffer) ClearCursors() {
 		b.cursors[i] = nil
 	}
 	b.cursors = b.cursors[:1]
-	b.UpdateCursors()
 	b.curCursor = 0
+	b.UpdateCursors()
 	b.GetActiveCursor().Deselect(true)
 }
 
@@ -1138,15 +1386,24 @@ var BracePairs = [][2]rune{
 
 func (b *Buffer) findMatchingBrace(braceType [2]rune, start Loc, char rune) (Loc, bool) {
 	var i int
+	// limit the number of characters scanned so that matching a brace on
+	// a huge line (e.g. minified code) does not stall the redraw. Runes are
+	// decoded one at a time so that the rest of the line is never touched
+	limit := util.IntOpt(b.Settings["matchbracelimit"])
+	scanned := 0
 	if char == braceType[0] {
 		for y := start.Y; y < b.LinesNum(); y++ {
-			l := []rune(string(b.LineBytes(y)))
-			xInit := 0
+			l := b.LineBytes(y)
+			x, off := 0, 0
 			if y == start.Y {
-				xInit = start.X
+				x, off = start.X, runeOffset(l, start.X)
 			}
-			for x := xInit; x < len(l); x++ {
-				r := l[x]
+			for off < len(l) {
+				scanned++
+				if limit > 0 && scanned > limit {
+					return start, false
+				}
+				r, size := utf8.DecodeRune(l[off:])
 				if r == braceType[0] {
 					i++
 				} else if r == braceType[1] {
@@ -1155,23 +1412,30 @@ func (b *Buffer) findMatchingBrace(braceType [2]rune, start Loc, char rune) (Loc
 						return Loc{x, y}, true
 					}
 				}
+				off += size
+				x++
 			}
 		}
 	} else if char == braceType[1] {
 		for y := start.Y; y >= 0; y-- {
-			l := []rune(string(b.lines[y].data))
-			xInit := len(l) - 1
+			l := b.LineBytes(y)
+			off := len(l)
 			if y == start.Y {
-				xInit = start.X
+				off = runeOffset(l, start.X+1)
 			}
-			for x := xInit; x >= 0; x-- {
-				r := l[x]
+			for off > 0 {
+				scanned++
+				if limit > 0 && scanned > limit {
+					return start, false
+				}
+				r, size := utf8.DecodeLastRune(l[:off])
+				off -= size
 				if r == braceType[1] {
 					i++
 				} else if r == braceType[0] {
 					i--
 					if i == 0 {
-						return Loc{x, y}, true
+						return Loc{utf8.RuneCount(l[:off]), y}, true
 					}
 				}
 			}
@@ -1180,19 +1444,40 @@ func (b *Buffer) findMatchingBrace(braceType [2]rune, start Loc, char rune) (Loc
 	return start, false
 }
 
+// runeOffset returns the byte offset of the rune at index n in l, or the
+// length of l if it has fewer runes
+func runeOffset(l []byte, n int) int {
+	off := 0
+	for ; n > 0 && off < len(l); n-- {
+		_, size := utf8.DecodeRune(l[off:])
+		off += size
+	}
+	return off
+}
+
+// runeAt returns the rune at index n in l, decoding only the runes before it
+func runeAt(l []byte, n int) (rune, bool) {
+	if n < 0 {
+		return 0, false
+	}
+	off := runeOffset(l, n)
+	if off >= len(l) {
+		return 0, false
+	}
+	r, _ := utf8.DecodeRune(l[off:])
+	return r, true
+}
+
 // If there is a brace character (for example '{' or ']') at the given start location,
 // FindMatchingBrace returns the location of the matching brace for it (for example '}'
 // or '['). The second returned value is true if there was no matching brace found
 // for given starting location but it was found for the location one character left
 // of it. The third returned value is true if the matching brace was found at all.
 func (b *Buffer) FindMatchingBrace(start Loc) (Loc, bool, bool) {
-	// TODO: maybe can be more efficient with utf8 package
-	curLine := []rune(string(b.LineBytes(start.Y)))
+	curLine := b.LineBytes(start.Y)
 
 	// first try to find matching brace for the given location (it has higher priority)
-	if start.X >= 0 && start.X < len(curLine) {
-		startChar := curLine[start.X]
-
+	if startChar, ok := runeAt(curLine, start.X); ok {
 		for _, bp := range BracePairs {
 			if startChar == bp[0] || startChar == bp[1] {
 				mb, found := b.findMatchingBrace(bp, start, startChar)
@@ -1206,8 +1491,7 @@ func (b *Buffer) FindMatchingBrace(start Loc) (Loc, bool, bool) {
 	if b.Settings["matchbraceleft"].(bool) {
 		// failed to find matching brace for the given location, so try to find matching
 		// brace for the location one character left of it
-		if start.X-1 >= 0 && start.X-1 < len(curLine) {
-			leftChar := curLine[start.X-1]
+		if leftChar, ok := runeAt(curLine, start.X-1); ok {
 			left := Loc{start.X - 1, start.Y}
 
 			for _, bp := range BracePairs {
@@ -1224,6 +1508,14 @@ func (b *Buffer) FindMatchingBrace(start Loc) (Loc, bool, bool) {
 	return start, false, false
 }
 
+// retabWhitespace converts leading whitespace to use spaces or tabs
+func retabWhitespace(ws []byte, toSpaces bool, tabsize int) []byte {
+	if toSpaces {
+		return bytes.ReplaceAll(ws, []byte{'\t'}, bytes.Repeat([]byte{' '}, tabsize))
+	}
+	return bytes.ReplaceAll(ws, bytes.Repeat([]byte{' '}, tabsize), []byte{'\t'})
+}
+
 // Retab changes all tabs to spaces or vice versa
 func (b *Buffer) Retab() {
 	toSpaces := b.Settings["tabstospaces"].(bool)
@@ -1235,11 +1527,7 @@ func (b *Buffer) Retab() {
 
 		ws := util.GetLeadingWhitespace(l)
 		if len(ws) != 0 {
-			if toSpaces {
-				ws = bytes.ReplaceAll(ws, []byte{'\t'}, bytes.Repeat([]byte{' '}, tabsize))
-			} else {
-				ws = bytes.ReplaceAll(ws, bytes.Repeat([]byte{' '}, tabsize), []byte{'\t'})
-			}
+			ws = retabWhitespace(ws, toSpaces, tabsize)
 		}
 
 		l = bytes.TrimLeft(l, " \t")
@@ -1255,6 +1543,188 @@ func (b *Buffer) Retab() {
 	b.isModified = dirty
 }
 
+// RetabLines changes the leading tabs to spaces or vice versa, depending
+// on the tabstospaces option, in the lines from start to end (inclusive).
+// Unlike Retab this is a single undoable event and leaves the other lines
+// untouched
+func (b *Buffer) RetabLines(start, end int) {
+	if b.Type.Readonly {
+		return
+	}
+	toSpaces := b.Settings["tabstospaces"].(bool)
+	tabsize := util.IntOpt(b.Settings["tabsize"])
+
+	var deltas []Delta
+	for i := start; i <= end; i++ {
+		ws := util.GetLeadingWhitespace(b.LineBytes(i))
+		fixed := retabWhitespace(ws, toSpaces, tabsize)
+		if bytes.Equal(ws, fixed) {
+			continue
+		}
+		deltas = append(deltas, Delta{fixed, Loc{0, i}, Loc{util.CharacterCount(ws), i}})
+	}
+	if len(deltas) == 0 {
+		return
+	}
+
+	b.EventHandler.cursors = b.cursors
+	b.EventHandler.active = b.curCursor
+	b.EventHandler.MultipleReplace(deltas)
+	b.RelocateCursors()
+
+	b.RequestBackup()
+}
+
+// splitVisual splits a line into pieces that are each at most width
+// visual columns wide, never splitting a character
+func splitVisual(l []byte, width, tabsize int) [][]byte {
+	var pieces [][]byte
+	for {
+		rest, _, n := util.SliceVisualEnd(l, width, tabsize)
+		if len(rest) == 0 {
+			return append(pieces, l)
+		}
+		if n == 0 {
+			// a single character is wider than the limit
+			_, _, size := util.DecodeCharacter(l)
+			rest = l[size:]
+		}
+		pieces = append(pieces, l[:len(l)-len(rest)])
+		l = rest
+	}
+}
+
+// TrimLines truncates the lines from start to end (inclusive) to at most
+// width visual columns, as a single undoable event. If wrap is true the
+// overflow is moved to new lines instead of being discarded. It returns
+// the number of lines that were changed
+func (b *Buffer) TrimLines(start, end, width int, wrap bool) int {
+	if b.Type.Readonly || width <= 0 {
+		return 0
+	}
+	tabsize := util.IntOpt(b.Settings["tabsize"])
+
+	var deltas []Delta
+	// go from the bottom up so that new lines don't shift the later deltas
+	for i := end; i >= start; i-- {
+		l := b.LineBytes(i)
+		pieces := splitVisual(l, width, tabsize)
+		if len(pieces) == 1 {
+			continue
+		}
+
+		var newLine []byte
+		if wrap {
+			newLine = bytes.Join(pieces, []byte{'\n'})
+		} else {
+			newLine = append([]byte{}, pieces[0]...)
+		}
+		deltas = append(deltas, Delta{newLine, Loc{0, i}, Loc{util.CharacterCount(l), i}})
+	}
+	if len(deltas) == 0 {
+		return 0
+	}
+
+	b.EventHandler.cursors = b.cursors
+	b.EventHandler.active = b.curCursor
+	b.EventHandler.MultipleReplace(deltas)
+	b.RelocateCursors()
+
+	b.RequestBackup()
+	return len(deltas)
+}
+
+// lineIndentsWithTabs reports whether line i is indented with a tab. The
+// second return value is false if the line is not indented or is blank
+func (b *Buffer) lineIndentsWithTabs(i int) (bool, bool) {
+	l := b.LineBytes(i)
+	ws := util.GetLeadingWhitespace(l)
+	if len(ws) == 0 || len(ws) == len(l) {
+		return false, false
+	}
+	return ws[0] == '\t', true
+}
+
+// DetectIndent reports whether the majority of the indented lines in the
+// buffer are indented with tabs. The second return value is false if no
+// line in the buffer is indented
+func (b *Buffer) DetectIndent() (bool, bool) {
+	tabs, spaces := 0, 0
+	for i := 0; i < b.LinesNum(); i++ {
+		if t, ok := b.lineIndentsWithTabs(i); ok {
+			if t {
+				tabs++
+			} else {
+				spaces++
+			}
+		}
+	}
+	if tabs == 0 && spaces == 0 {
+		return false, false
+	}
+	return tabs > spaces, true
+}
+
+// MixedIndentLines returns the numbers of the lines whose indentation style
+// differs from the majority style found by DetectIndent
+func (b *Buffer) MixedIndentLines() []int {
+	var lines []int
+	tabs, ok := b.DetectIndent()
+	if !ok {
+		return lines
+	}
+	for i := 0; i < b.LinesNum(); i++ {
+		if t, ok := b.lineIndentsWithTabs(i); ok && t != tabs {
+			lines = append(lines, i)
+		}
+	}
+	return lines
+}
+
+// TrailingWhitespaceLines returns the numbers of the lines that end with
+// whitespace
+func (b *Buffer) TrailingWhitespaceLines() []int {
+	var lines []int
+	for i := 0; i < b.LinesNum(); i++ {
+		if util.HasTrailingWhitespace(b.LineBytes(i)) {
+			lines = append(lines, i)
+		}
+	}
+	return lines
+}
+
+// FixIndent retabs the lines returned by MixedIndentLines to the majority
+// indentation style as a single undoable event. It returns the number of
+// lines that were changed
+func (b *Buffer) FixIndent() int {
+	if b.Type.Readonly {
+		return 0
+	}
+	tabs, _ := b.DetectIndent()
+	tabsize := util.IntOpt(b.Settings["tabsize"])
+
+	var deltas []Delta
+	for _, i := range b.MixedIndentLines() {
+		ws := util.GetLeadingWhitespace(b.LineBytes(i))
+		fixed := retabWhitespace(ws, !tabs, tabsize)
+		if bytes.Equal(ws, fixed) {
+			continue
+		}
+		deltas = append(deltas, Delta{fixed, Loc{0, i}, Loc{util.CharacterCount(ws), i}})
+	}
+	if len(deltas) == 0 {
+		return 0
+	}
+
+	b.EventHandler.cursors = b.cursors
+	b.EventHandler.active = b.curCursor
+	b.EventHandler.MultipleReplace(deltas)
+	b.RelocateCursors()
+
+	b.RequestBackup()
+	return len(deltas)
+}
+
 // ParseCursorLocation turns a cursor location like 10:5 (LINE:COL)
 // into a loc
 func ParseCursorLocation(cursorPositions []string) (Loc, error) {
@@ -1300,8 +1770,6 @@ func (b *Buffer) updateDiff(synchronous bool) {
 		return
 	}
 
-	differ := dmp.New()
-
 	if !synchronous {
 		b.Lock()
 	}
@@ -1310,7 +1778,16 @@ func (b *Buffer) updateDiff(synchronous bool) {
 		b.Unlock()
 	}
 
-	baseRunes, bufferRunes, _ := differ.DiffLinesToRunes(string(b.diffBase), string(bytes))
+	b.diff = diffLines(string(b.diffBase), string(bytes))
+}
+
+// diffLines computes the diff status of each line of text compared to base.
+// Unchanged lines are not included in the result
+func diffLines(base, text string) map[int]DiffStatus {
+	differ := dmp.New()
+	result := make(map[int]DiffStatus)
+
+	baseRunes, bufferRunes, _ := differ.DiffLinesToRunes(base, text)
 	diffs := differ.DiffMainRunes(baseRunes, bufferRunes, false)
 	lineN := 0
 
@@ -1322,19 +1799,81 @@ func (b *Buffer) updateDiff(synchronous bool) {
 			lineN += lineCount
 		case dmp.DiffInsert:
 			var status DiffStatus
-			if b.diff[lineN] == DSDeletedAbove {
+			if result[lineN] == DSDeletedAbove {
 				status = DSModified
 			} else {
 				status = DSAdded
 			}
 			for i := 0; i < lineCount; i++ {
-				b.diff[lineN] = status
+				result[lineN] = status
 				lineN++
 			}
 		case dmp.DiffDelete:
-			b.diff[lineN] = DSDeletedAbove
+			result[lineN] = DSDeletedAbove
 		}
 	}
+	return result
+}
+
+// savedDiffCache holds the result of DiffAgainstSaved and the saved file
+// contents it was computed from
+type savedDiffCache struct {
+	edits   int
+	modTime time.Time
+	diff    map[int]int
+
+	// the decoded file contents and the modification time and size of the
+	// file they were read from
+	saved     []byte
+	savedTime time.Time
+	savedSize int64
+	hasSaved  bool
+}
+
+// savedContent returns the contents of the buffer's file on disk. They are
+// only read again when the modification time or the size of the file change
+func (b *Buffer) savedContent() []byte {
+	c := &b.savedDiffCache
+	if b.Path == "" {
+		return nil
+	}
+	info, err := os.Stat(b.Path)
+	if err != nil {
+		c.saved, c.hasSaved = nil, false
+		return nil
+	}
+	if c.hasSaved && c.savedTime.Equal(info.ModTime()) && c.savedSize == info.Size() {
+		return c.saved
+	}
+	saved, err := b.readFromDisk()
+	if err != nil {
+		c.saved, c.hasSaved = nil, false
+		return nil
+	}
+	c.saved, c.savedTime, c.savedSize, c.hasSaved = saved, info.ModTime(), info.Size(), true
+	return saved
+}
+
+// DiffAgainstSaved returns the diff status of the lines of the buffer
+// compared to its file on disk, as a map from line number to DSAdded,
+// DSModified or DSDeletedAbove. Unchanged lines are not included. If the
+// buffer has no file on disk yet every line counts as added. The result is cached
+// until the buffer is edited or the file is saved or reloaded, and must not
+// be modified by the caller. The file itself is only read again when it
+// changes on disk
+func (b *Buffer) DiffAgainstSaved() map[int]int {
+	c := &b.savedDiffCache
+	if c.diff != nil && c.edits == b.edits && c.modTime.Equal(b.ModTime) {
+		return c.diff
+	}
+
+	saved := b.savedContent()
+	diff := make(map[int]int)
+	for line, status := range diffLines(string(saved), string(b.Bytes())) {
+		diff[line] = int(status)
+	}
+	c.edits, c.modTime, c.diff = b.edits, b.ModTime, diff
+	return diff
 }
 
 // UpdateDiff computes the diff between the diff base and the buffer content.
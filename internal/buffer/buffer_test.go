@@ -1,9 +1,16 @@
 package buffer
 
 import (
+	"bytes"
+	"errors"
 	"math/rand"
+	"os"
+	"path/filepath"
+	"regexp"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/zyedidia/micro/v2/internal/config"
@@ -93,6 +100,655 @@ func check(t *testing.T, before []string, operations []operation, after []string
 	b.Close()
 }
 
+func TestSelectOnUndo(t *testing.T) {
+	b := NewBufferFromString("foo bar baz", "", BTDefault)
+	defer b.Close()
+	b.Settings["selectonundo"] = true
+	c := b.GetActiveCursor()
+
+	// undoing a removal selects the text that was put back
+	b.Remove(Loc{4, 0}, Loc{8, 0})
+	assert.Equal(t, "foo baz", string(b.Bytes()))
+	b.UndoOneEvent()
+	assert.Equal(t, "foo bar baz", string(b.Bytes()))
+	assert.Equal(t, [2]Loc{{4, 0}, {8, 0}}, c.CurSelection)
+	assert.Equal(t, "bar ", string(c.GetSelection()))
+
+	// undoing a replace selects the original text
+	c.ResetSelection()
+	b.Replace(Loc{0, 0}, Loc{3, 0}, "quux")
+	assert.Equal(t, "quux bar baz", string(b.Bytes()))
+	b.Undo()
+	assert.Equal(t, "foo bar baz", string(b.Bytes()))
+	assert.Equal(t, "foo", string(c.GetSelection()))
+
+	// undoing an insert leaves an empty selection where the text was
+	c.ResetSelection()
+	b.Insert(Loc{3, 0}, "!!")
+	b.UndoOneEvent()
+	assert.Equal(t, "foo bar baz", string(b.Bytes()))
+	assert.False(t, c.HasSelection())
+	assert.Equal(t, Loc{3, 0}, c.Loc)
+}
+
+func TestUndoThreshold(t *testing.T) {
+	// three inserts at 0ms, 100ms and 1500ms past a second boundary, each at
+	// the start of the buffer so that they are not coalesced
+	offsets := []time.Duration{0, 100 * time.Millisecond, 1500 * time.Millisecond}
+	setup := func(threshold float64) *Buffer {
+		b := NewBufferFromString("", "", BTDefault)
+		b.Settings["undothreshold"] = threshold
+		for i := range offsets {
+			b.Insert(b.Start(), string(rune('a'+i)))
+		}
+		e := b.UndoStack.Top
+		for i := len(offsets) - 1; i >= 0; i-- {
+			e.Value.Time = time.Unix(1000, 0).Add(offsets[i])
+			e = e.Next
+		}
+		return b
+	}
+
+	b := setup(1000)
+	b.Undo()
+	assert.Equal(t, "ba", string(b.Bytes()))
+	b.Undo()
+	assert.Equal(t, "", string(b.Bytes()))
+	b.Redo()
+	assert.Equal(t, "ba", string(b.Bytes()))
+	b.Close()
+
+	b = setup(2000)
+	b.Undo()
+	assert.Equal(t, "", string(b.Bytes()))
+	b.Redo()
+	assert.Equal(t, "cba", string(b.Bytes()))
+	b.Close()
+
+	b = setup(0)
+	b.Undo()
+	assert.Equal(t, "ba", string(b.Bytes()))
+	b.Undo()
+	assert.Equal(t, "a", string(b.Bytes()))
+	b.Redo()
+	assert.Equal(t, "ba", string(b.Bytes()))
+	b.Close()
+}
+
+func TestStackSizes(t *testing.T) {
+	b := NewBufferFromString("", "", BTDefault)
+	defer b.Close()
+	assert.False(t, b.CanUndo())
+	assert.False(t, b.CanRedo())
+
+	b.Insert(Loc{0, 0}, "foo")
+	b.Insert(Loc{3, 0}, "bar")
+	assert.Equal(t, 2, b.UndoStackSize())
+	assert.Equal(t, 0, b.RedoStackSize())
+	assert.True(t, b.CanUndo())
+
+	b.UndoOneEvent()
+	assert.Equal(t, 1, b.UndoStackSize())
+	assert.Equal(t, 1, b.RedoStackSize())
+	assert.True(t, b.CanRedo())
+	assert.Equal(t, "foo", string(b.Bytes()))
+}
+
+func TestUndoHistoryCap(t *testing.T) {
+	b := NewBufferFromString("", "", BTDefault)
+	defer b.Close()
+	// keep single character inserts from being coalesced
+	b.Settings["undothreshold"] = float64(0)
+	b.MaxUndoEvents = 3
+
+	for _, w := range []string{"a", "b", "c", "d", "e"} {
+		b.Insert(b.End(), w)
+	}
+	assert.Equal(t, 3, b.UndoStackSize())
+
+	// only the three newest inserts can be undone
+	for b.CanUndo() {
+		b.UndoOneEvent()
+	}
+	assert.Equal(t, "ab", string(b.Bytes()))
+	for b.CanRedo() {
+		b.RedoOneEvent()
+	}
+	assert.Equal(t, "abcde", string(b.Bytes()))
+
+	b.MaxUndoEvents = 0
+	b.MaxUndoBytes = 8
+	b.Insert(b.End(), "12345")
+	b.Insert(b.End(), "6789")
+	assert.Equal(t, 1, b.UndoStackSize())
+	b.UndoOneEvent()
+	assert.Equal(t, "abcde12345", string(b.Bytes()))
+
+	// an event larger than the budget is still kept
+	b.Insert(b.End(), "0123456789")
+	assert.Equal(t, 1, b.UndoStackSize())
+}
+
+func TestHistoryRoundTrip(t *testing.T) {
+	b := NewBufferFromString("foo", "", BTDefault)
+	defer b.Close()
+	b.Insert(Loc{3, 0}, " bar")
+	b.Replace(Loc{0, 0}, Loc{3, 0}, "baz")
+	b.Remove(Loc{3, 0}, Loc{7, 0})
+	b.UndoOneEvent()
+	assert.Equal(t, "baz bar", string(b.Bytes()))
+
+	var hist bytes.Buffer
+	assert.NoError(t, b.SaveHistory(&hist))
+	data := hist.Bytes()
+
+	other := NewBufferFromString("baz bar", "", BTDefault)
+	defer other.Close()
+	assert.NoError(t, other.LoadHistory(bytes.NewReader(data)))
+	assert.Equal(t, 3, other.UndoStackSize())
+	assert.Equal(t, 1, other.RedoStackSize())
+
+	other.RedoOneEvent()
+	assert.Equal(t, "baz", string(other.Bytes()))
+	for other.CanUndo() {
+		other.UndoOneEvent()
+	}
+	assert.Equal(t, "foo", string(other.Bytes()))
+
+	changed := NewBufferFromString("baz bar!", "", BTDefault)
+	defer changed.Close()
+	assert.Equal(t, ErrHistoryMismatch, changed.LoadHistory(bytes.NewReader(data)))
+	assert.False(t, changed.CanUndo())
+}
+
+func TestSerializeHistory(t *testing.T) {
+	configDir := config.ConfigDir
+	config.ConfigDir = t.TempDir()
+	defer func() { config.ConfigDir = configDir }()
+	config.GlobalSettings["saveundo"] = true
+	defer func() { config.GlobalSettings["saveundo"] = false }()
+
+	path := filepath.Join(t.TempDir(), "undo.txt")
+	if err := os.WriteFile(path, []byte("foo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewBufferFromFile(path, BTDefault)
+	assert.NoError(t, err)
+	b.Insert(Loc{3, 0}, " bar")
+	assert.NoError(t, b.Save())
+	b.Close()
+
+	b, err = NewBufferFromFile(path, BTDefault)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, b.UndoStackSize())
+	b.UndoOneEvent()
+	assert.Equal(t, "foo\n", string(b.Bytes()))
+	b.RedoOneEvent()
+	b.Close()
+
+	// Same modification time but different contents: the hash must reject
+	// the saved history
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	if err := os.WriteFile(path, []byte("foo baz\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, os.Chtimes(path, info.ModTime(), info.ModTime()))
+
+	b, err = NewBufferFromFile(path, BTDefault)
+	assert.NoError(t, err)
+	defer b.Close()
+	assert.False(t, b.CanUndo())
+}
+
+func TestUndoTree(t *testing.T) {
+	b := NewBufferFromString("", "", BTDefault)
+	defer b.Close()
+	// keep single character inserts from being coalesced
+	b.Settings["undothreshold"] = float64(0)
+
+	// without undotree the undone edit is discarded
+	b.Insert(Loc{0, 0}, "a")
+	b.Insert(Loc{1, 0}, "b")
+	b.UndoOneEvent()
+	b.Insert(Loc{1, 0}, "c")
+	b.UndoOneEvent()
+	assert.Len(t, b.Branches(), 1)
+
+	b.Settings["undotree"] = true
+	b.Insert(Loc{1, 0}, "d")
+	b.Insert(Loc{2, 0}, "e")
+	b.UndoOneEvent()
+	b.UndoOneEvent()
+	assert.Equal(t, "a", string(b.Bytes()))
+	assert.Len(t, b.Branches(), 2)
+
+	// redo follows the newest branch by default
+	b.RedoOneEvent()
+	b.RedoOneEvent()
+	assert.Equal(t, "ade", string(b.Bytes()))
+	assert.False(t, b.CanRedo())
+
+	b.UndoOneEvent()
+	b.UndoOneEvent()
+	b.SwitchBranch(0)
+	b.RedoOneEvent()
+	assert.Equal(t, "ac", string(b.Bytes()))
+	assert.False(t, b.CanRedo())
+
+	b.UndoOneEvent()
+	b.SwitchBranch(1)
+	b.RedoOneEvent()
+	b.RedoOneEvent()
+	assert.Equal(t, "ade", string(b.Bytes()))
+	for b.CanUndo() {
+		b.UndoOneEvent()
+	}
+	assert.Equal(t, "", string(b.Bytes()))
+}
+
+func TestApplyDiffWordMode(t *testing.T) {
+	old := "the quick brown fox\njumps over the lazy dog\nand then it runs away\n"
+	new := "a sleepy red cat\nstrolls past one idle hound\nwhich barely notices\n"
+
+	b := NewBufferFromString(old, "", BTDefault)
+	defer b.Close()
+	b.ApplyDiffMode(new, false)
+	assert.Equal(t, new, string(b.Bytes()))
+	charEvents := b.UndoStackSize()
+
+	w := NewBufferFromString(old, "", BTDefault)
+	defer w.Close()
+	w.ApplyDiffMode(new, true)
+	assert.Equal(t, new, string(w.Bytes()))
+	wordEvents := w.UndoStackSize()
+
+	assert.True(t, wordEvents < charEvents, "word mode: %d events, char mode: %d", wordEvents, charEvents)
+
+	for w.CanUndo() {
+		w.UndoOneEvent()
+	}
+	assert.Equal(t, old, string(w.Bytes()))
+}
+
+func TestApplyDiffWithTimeout(t *testing.T) {
+	// random texts with no structure in common are slow to diff
+	gen := func(seed int64) string {
+		r := rand.New(rand.NewSource(seed))
+		var sb strings.Builder
+		for i := 0; i < 20000; i++ {
+			if r.Intn(40) == 0 {
+				sb.WriteByte('\n')
+			} else {
+				sb.WriteByte(byte('a' + r.Intn(4)))
+			}
+		}
+		return sb.String()
+	}
+	old, new := gen(1), gen(2)
+
+	b := NewBufferFromString(old, "", BTDefault)
+	defer b.Close()
+	b.ApplyDiffWithTimeout(new, time.Millisecond)
+	assert.Equal(t, new, string(b.Bytes()))
+	assert.Equal(t, 1, b.UndoStackSize())
+
+	b.UndoOneEvent()
+	assert.Equal(t, old, string(b.Bytes()))
+
+	s := NewBufferFromString("foo bar", "", BTDefault)
+	defer s.Close()
+	s.ApplyDiffWithTimeout("foo baz", 0)
+	assert.Equal(t, "foo baz", string(s.Bytes()))
+}
+
+func TestUndoGroup(t *testing.T) {
+	b := NewBufferFromString("", "", BTDefault)
+	defer b.Close()
+
+	b.Insert(Loc{0, 0}, "x")
+	b.BeginUndoGroup()
+	b.Insert(Loc{1, 0}, "a")
+	b.BeginUndoGroup()
+	b.Insert(Loc{2, 0}, "b")
+	b.EndUndoGroup()
+	b.Insert(Loc{3, 0}, "c")
+	b.EndUndoGroup()
+	b.Insert(Loc{4, 0}, "y")
+
+	// spread the events far apart in time
+	i := 0
+	for e := b.UndoStack.Top; e != nil; e = e.Next {
+		e.Value.Time = time.Unix(int64(1000-10*i), 0)
+		i++
+	}
+
+	b.Undo()
+	assert.Equal(t, "xabc", string(b.Bytes()))
+	b.Undo()
+	assert.Equal(t, "x", string(b.Bytes()))
+	b.Redo()
+	assert.Equal(t, "xabc", string(b.Bytes()))
+	b.Undo()
+	b.Undo()
+	assert.Equal(t, "", string(b.Bytes()))
+}
+
+func TestOnChange(t *testing.T) {
+	b := NewBufferFromString("foo\nbar", "", BTDefault)
+	defer b.Close()
+	shadow := NewBufferFromString("foo\nbar", "", BTDefault)
+	defer shadow.Close()
+
+	events := 0
+	b.OnChange = func(e *TextEvent) {
+		events++
+		c := *e
+		c.Deltas = append([]Delta(nil), e.Deltas...)
+		ExecuteTextEvent(&c, shadow.SharedBuffer)
+	}
+
+	b.Insert(Loc{3, 0}, " baz\nqux")
+	b.Remove(Loc{0, 0}, Loc{2, 1})
+	b.Replace(Loc{0, 0}, Loc{1, 0}, "zz")
+	b.MultipleReplace([]Delta{
+		{[]byte("1"), Loc{0, 1}, Loc{1, 1}},
+		{[]byte("2\n3"), Loc{0, 0}, Loc{0, 0}},
+	})
+	assert.Equal(t, string(b.Bytes()), string(shadow.Bytes()))
+
+	b.UndoOneEvent()
+	b.UndoOneEvent()
+	assert.Equal(t, string(b.Bytes()), string(shadow.Bytes()))
+	b.RedoOneEvent()
+	assert.Equal(t, string(b.Bytes()), string(shadow.Bytes()))
+	assert.Equal(t, 8, events)
+}
+
+func TestDropRedo(t *testing.T) {
+	b := NewBufferFromString("", "", BTDefault)
+	defer b.Close()
+	b.Settings["undothreshold"] = float64(0)
+
+	b.Insert(Loc{0, 0}, "a")
+	b.Insert(Loc{0, 0}, "b")
+	b.Insert(Loc{0, 0}, "c")
+	b.Insert(Loc{0, 0}, "d")
+	for b.CanUndo() {
+		b.Undo()
+	}
+	assert.Equal(t, 4, b.RedoStackSize())
+
+	b.Redo()
+	assert.Equal(t, "a", string(b.Bytes()))
+	b.DropRedo(2)
+	assert.Equal(t, 1, b.RedoStackSize())
+	assert.Len(t, b.Branches(), 1)
+	b.Redo()
+	assert.Equal(t, "da", string(b.Bytes()))
+	assert.False(t, b.CanRedo())
+
+	b.Undo()
+	b.DropRedo(5)
+	assert.Equal(t, 0, b.RedoStackSize())
+	assert.Len(t, b.Branches(), 0)
+	assert.Equal(t, "a", string(b.Bytes()))
+}
+
+func TestReloadKeepsUndo(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "reload.txt")
+	if err := os.WriteFile(path, []byte("foo\nbar\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := NewBufferFromFile(path, BTDefault)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	if err := os.WriteFile(path, []byte("foo\nbaz\nqux\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.NoError(t, b.Reload())
+	assert.Equal(t, "foo\nbaz\nqux\n", string(b.Bytes()))
+	assert.False(t, b.Modified())
+
+	b.Undo()
+	assert.Equal(t, "foo\nbar\n", string(b.Bytes()))
+
+	b.Redo()
+	assert.Equal(t, "foo\nbaz\nqux\n", string(b.Bytes()))
+
+	assert.NoError(t, b.HardReload())
+	assert.Equal(t, "foo\nbaz\nqux\n", string(b.Bytes()))
+	assert.Equal(t, 0, b.UndoStack.Len())
+	assert.Equal(t, 0, b.RedoStack.Len())
+}
+
+func TestMatchBraceLimit(t *testing.T) {
+	line := "(" + strings.Repeat("a", 1000000) + ")"
+	b := NewBufferFromString(line+"\n(b)", "", BTDefault)
+	defer b.Close()
+	b.Settings["matchbracelimit"] = float64(1000)
+
+	start := time.Now()
+	_, _, found := b.FindMatchingBrace(Loc{0, 0})
+	assert.False(t, found)
+	_, _, found = b.FindMatchingBrace(Loc{len(line) - 1, 0})
+	assert.False(t, found)
+	assert.True(t, time.Since(start) < time.Second)
+
+	mb, _, found := b.FindMatchingBrace(Loc{0, 1})
+	assert.True(t, found)
+	assert.Equal(t, Loc{2, 1}, mb)
+
+	b.Settings["matchbracelimit"] = float64(0)
+	mb, _, found = b.FindMatchingBrace(Loc{0, 0})
+	assert.True(t, found)
+	assert.Equal(t, Loc{len(line) - 1, 0}, mb)
+
+	// locations count runes, not bytes
+	b = NewBufferFromString("é(ü[x]ö)é\nà)", "", BTDefault)
+	defer b.Close()
+	mb, _, found = b.FindMatchingBrace(Loc{1, 0})
+	assert.True(t, found)
+	assert.Equal(t, Loc{7, 0}, mb)
+	mb, _, found = b.FindMatchingBrace(Loc{5, 0})
+	assert.True(t, found)
+	assert.Equal(t, Loc{3, 0}, mb)
+	_, _, found = b.FindMatchingBrace(Loc{1, 1})
+	assert.False(t, found)
+}
+
+func TestMixedIndent(t *testing.T) {
+	b := NewBufferFromString("func() {\n    a\n\tb\n    c\n\td\n    e\n}\n", "", BTDefault)
+	defer b.Close()
+	b.Settings["tabsize"] = float64(4)
+
+	tabs, ok := b.DetectIndent()
+	assert.True(t, ok)
+	assert.False(t, tabs)
+	assert.Equal(t, []int{2, 4}, b.MixedIndentLines())
+
+	assert.Equal(t, 2, b.FixIndent())
+	assert.Equal(t, "func() {\n    a\n    b\n    c\n    d\n    e\n}\n", string(b.Bytes()))
+	assert.Empty(t, b.MixedIndentLines())
+
+	b.UndoOneEvent()
+	assert.Equal(t, "func() {\n    a\n\tb\n    c\n\td\n    e\n}\n", string(b.Bytes()))
+}
+
+func TestWhitespaceReport(t *testing.T) {
+	b := NewBufferFromString("if x {\t\n    a \n\tb\n\n    c\n  \n\td\t \n}", "", BTDefault)
+	defer b.Close()
+
+	assert.Equal(t, []int{0, 1, 5, 6}, b.TrailingWhitespaceLines())
+	assert.Equal(t, []int{2, 6}, b.MixedIndentLines())
+
+	clean := NewBufferFromString("a\n\tb\n", "", BTDefault)
+	defer clean.Close()
+	assert.Empty(t, clean.TrailingWhitespaceLines())
+	assert.Empty(t, clean.MixedIndentLines())
+}
+
+func TestDupComment(t *testing.T) {
+	b := NewBufferFromString("func main() {\n\tfmt.Println(x)\n}\n", "", BTDefault)
+	defer b.Close()
+	b.Settings["filetype"] = "go"
+
+	c := b.GetActiveCursor()
+	c.Loc = Loc{5, 1}
+	assert.NoError(t, b.DupComment(1))
+
+	assert.Equal(t, "func main() {\n\t// fmt.Println(x)\n\tfmt.Println(x)\n}\n", string(b.Bytes()))
+	assert.Equal(t, Loc{5, 2}, c.Loc)
+
+	b.UndoOneEvent()
+	assert.Equal(t, "func main() {\n\tfmt.Println(x)\n}\n", string(b.Bytes()))
+}
+
+func TestCommentType(t *testing.T) {
+	b := NewBufferFromString("x = 1\n", "", BTDefault)
+	defer b.Close()
+
+	b.Settings["filetype"] = "c"
+	ct, err := b.CommentType()
+	assert.NoError(t, err)
+	assert.Equal(t, "// %s", ct)
+
+	b.Settings["filetype"] = "nosuchfiletype"
+	_, err = b.CommentType()
+	assert.Error(t, err)
+	assert.Error(t, b.DupComment(0))
+	assert.Equal(t, "x = 1\n", string(b.Bytes()))
+
+	b.Settings["commenttype"] = "REM %s"
+	assert.NoError(t, b.DupComment(0))
+	assert.Equal(t, "REM x = 1\nx = 1\n", string(b.Bytes()))
+}
+
+func TestApplyEdits(t *testing.T) {
+	b := NewBufferFromString("foo bar\nbaz foo\nfoo", "", BTDefault)
+	defer b.Close()
+
+	err := b.ApplyEdits([]Edit{
+		{Loc{4, 1}, Loc{7, 1}, "quux"},
+		{Loc{0, 0}, Loc{3, 0}, "a\nb"},
+		{Loc{0, 2}, Loc{3, 2}, ""},
+		{Loc{7, 0}, Loc{7, 0}, "!"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "a\nb bar!\nbaz quux\n", string(b.Bytes()))
+
+	b.UndoOneEvent()
+	assert.Equal(t, "foo bar\nbaz foo\nfoo", string(b.Bytes()))
+
+	b.RedoOneEvent()
+	assert.Equal(t, "a\nb bar!\nbaz quux\n", string(b.Bytes()))
+}
+
+func TestApplyEditsOverlap(t *testing.T) {
+	b := NewBufferFromString("foo bar\nbaz", "", BTDefault)
+	defer b.Close()
+
+	err := b.ApplyEdits([]Edit{
+		{Loc{0, 1}, Loc{3, 1}, "x"},
+		{Loc{0, 0}, Loc{5, 0}, "y"},
+		{Loc{4, 0}, Loc{7, 0}, "z"},
+	})
+	assert.Error(t, err)
+	assert.Equal(t, "foo bar\nbaz", string(b.Bytes()))
+	assert.Equal(t, 0, b.UndoStack.Len())
+
+	err = b.ApplyEdits([]Edit{
+		{Loc{1, 0}, Loc{1, 0}, "x"},
+		{Loc{1, 0}, Loc{2, 0}, "y"},
+	})
+	assert.Error(t, err)
+	assert.Equal(t, "foo bar\nbaz", string(b.Bytes()))
+}
+
+func TestTrimLines(t *testing.T) {
+	b := NewBufferFromString("short\n\tabcdefghij\n0123456789abc\nñññññññññññ", "", BTDefault)
+	defer b.Close()
+	b.Settings["tabsize"] = float64(4)
+
+	assert.Equal(t, 3, b.TrimLines(0, 3, 10, false))
+	assert.Equal(t, "short\n\tabcdef\n0123456789\nññññññññññ", string(b.Bytes()))
+
+	b.UndoOneEvent()
+	assert.Equal(t, "short\n\tabcdefghij\n0123456789abc\nñññññññññññ", string(b.Bytes()))
+
+	assert.Equal(t, 2, b.TrimLines(1, 2, 10, true))
+	assert.Equal(t, "short\n\tabcdef\nghij\n0123456789\nabc\nñññññññññññ", string(b.Bytes()))
+
+	b.UndoOneEvent()
+	assert.Equal(t, "short\n\tabcdefghij\n0123456789abc\nñññññññññññ", string(b.Bytes()))
+}
+
+func TestSession(t *testing.T) {
+	configDir := config.ConfigDir
+	config.ConfigDir = t.TempDir()
+	defer func() { config.ConfigDir = configDir }()
+
+	dir := t.TempDir()
+	pathA := filepath.Join(dir, "a.txt")
+	pathB := filepath.Join(dir, "b.txt")
+	pathC := filepath.Join(dir, "c.txt")
+	for _, p := range []string{pathA, pathB, pathC} {
+		if err := os.WriteFile(p, []byte("one\ntwo\nthree\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	a, err := NewBufferFromFile(pathA, BTDefault)
+	assert.NoError(t, err)
+	b, err := NewBufferFromFile(pathB, BTDefault)
+	assert.NoError(t, err)
+	c, err := NewBufferFromFile(pathC, BTDefault)
+	assert.NoError(t, err)
+	a.GetActiveCursor().GotoLoc(Loc{1, 2})
+	b.GetActiveCursor().GotoLoc(Loc{2, 1})
+
+	assert.NoError(t, WriteSession([]*Buffer{a, b, c}, b))
+	a.Close()
+	b.Close()
+	c.Close()
+	os.Remove(pathC)
+
+	bufs, active, missing, err := ReadSession()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{c.AbsPath}, missing)
+	if assert.Len(t, bufs, 2) {
+		assert.Equal(t, a.AbsPath, bufs[0].AbsPath)
+		assert.Equal(t, Loc{1, 2}, bufs[0].GetActiveCursor().Loc)
+		assert.Equal(t, b.AbsPath, bufs[1].AbsPath)
+		assert.Equal(t, Loc{2, 1}, bufs[1].GetActiveCursor().Loc)
+		assert.Equal(t, 1, active)
+	}
+	for _, buf := range bufs {
+		buf.Close()
+	}
+}
+
+func TestRetabLines(t *testing.T) {
+	before := "\tone\n\ttwo\n\t\tthree\n\tfour\n"
+	b := NewBufferFromString(before, "", BTDefault)
+	defer b.Close()
+	b.Settings["tabsize"] = float64(2)
+	b.Settings["tabstospaces"] = true
+
+	b.RetabLines(1, 2)
+	assert.Equal(t, "\tone\n  two\n    three\n\tfour\n", string(b.Bytes()))
+	assert.Equal(t, []byte("\tone"), b.LineBytes(0))
+	assert.Equal(t, []byte("\tfour"), b.LineBytes(3))
+
+	b.UndoOneEvent()
+	assert.Equal(t, before, string(b.Bytes()))
+}
+
 const maxLineLength = 200
 
 var alphabet = []rune(" abcdeäم📚")
@@ -318,3 +974,702 @@ func BenchmarkEdit1000000Lines100Cursors(b *testing.B) {
 func BenchmarkEdit1000000Lines1000Cursors(b *testing.B) {
 	benchEdit(b, 1000000, 1000)
 }
+
+func TestRecentBuffers(t *testing.T) {
+	a := NewBufferFromString("a", "", BTDefault)
+	b := NewBufferFromString("b", "", BTDefault)
+	c := NewBufferFromString("c", "", BTDefault)
+	defer CloseOpenBuffers()
+
+	a.Activate()
+	b.Activate()
+	c.Activate()
+	assert.Equal(t, []*Buffer{c, b, a}, RecentBuffers())
+
+	b.Activate()
+	assert.Equal(t, []*Buffer{b, c, a}, RecentBuffers())
+
+	c.Close()
+	assert.Equal(t, []*Buffer{b, a}, RecentBuffers())
+}
+
+func TestInsertAt(t *testing.T) {
+	b := NewBufferFromString("ab\nc", "", BTDefault)
+	defer b.Close()
+
+	b.InsertAt(Loc{5, 0}, "x", false)
+	assert.Equal(t, "abx\nc", string(b.Bytes()))
+
+	b.InsertAt(Loc{6, 0}, "y", true)
+	assert.Equal(t, "abx   y\nc", string(b.Bytes()))
+
+	// the padding is undone with the text
+	b.UndoOneEvent()
+	assert.Equal(t, "abx\nc", string(b.Bytes()))
+
+	// the last line is padded too
+	b.InsertAt(Loc{3, 1}, "z", true)
+	assert.Equal(t, "abx\nc  z", string(b.Bytes()))
+
+	b.InsertAt(Loc{1, 0}, "é", true)
+	b.InsertAt(Loc{5, 0}, "!", true)
+	assert.Equal(t, "aébx !\nc  z", string(b.Bytes()))
+}
+
+func TestMoveText(t *testing.T) {
+	b := NewBufferFromString("one\ntwo\nthree\n", "", BTDefault)
+	defer b.Close()
+
+	// move line 2 above line 1
+	b.MoveText(Loc{0, 1}, Loc{0, 2}, Loc{0, 0})
+	assert.Equal(t, "two\none\nthree\n", string(b.Bytes()))
+	c := b.GetActiveCursor()
+	assert.Equal(t, [2]Loc{{0, 0}, {0, 1}}, c.CurSelection)
+	assert.Equal(t, 1, b.UndoStackSize())
+
+	b.UndoOneEvent()
+	assert.Equal(t, "one\ntwo\nthree\n", string(b.Bytes()))
+
+	// move line 1 below line 3
+	b.MoveText(Loc{0, 0}, Loc{0, 1}, Loc{0, 3})
+	assert.Equal(t, "two\nthree\none\n", string(b.Bytes()))
+	assert.Equal(t, [2]Loc{{0, 2}, {0, 3}}, c.CurSelection)
+	assert.Equal(t, "one\n", string(c.GetSelection()))
+
+	// moving a range into itself does nothing
+	b.MoveText(Loc{0, 0}, Loc{0, 2}, Loc{1, 1})
+	assert.Equal(t, "two\nthree\none\n", string(b.Bytes()))
+}
+
+func TestDuplicate(t *testing.T) {
+	b := NewBufferFromString("one two\nthree", "", BTDefault)
+	defer b.Close()
+	c := b.GetActiveCursor()
+
+	// mid-line selection
+	b.Duplicate(Loc{4, 0}, Loc{7, 0})
+	assert.Equal(t, "one twotwo\nthree", string(b.Bytes()))
+	assert.Equal(t, "two", string(c.GetSelection()))
+	assert.Equal(t, [2]Loc{{7, 0}, {10, 0}}, c.CurSelection)
+	assert.Equal(t, 1, b.UndoStackSize())
+	b.UndoOneEvent()
+	assert.Equal(t, "one two\nthree", string(b.Bytes()))
+
+	// full line including its newline
+	b.Duplicate(Loc{0, 0}, Loc{0, 1})
+	assert.Equal(t, "one two\none two\nthree", string(b.Bytes()))
+	assert.Equal(t, [2]Loc{{0, 1}, {0, 2}}, c.CurSelection)
+	b.UndoOneEvent()
+
+	// full last line without a newline
+	b.Duplicate(Loc{0, 1}, Loc{5, 1})
+	assert.Equal(t, "one two\nthree\nthree", string(b.Bytes()))
+	assert.Equal(t, "three", string(c.GetSelection()))
+	b.UndoOneEvent()
+
+	// no selection duplicates the line
+	b.Duplicate(Loc{2, 0}, Loc{2, 0})
+	assert.Equal(t, "one two\none two\nthree", string(b.Bytes()))
+	assert.Equal(t, Loc{2, 1}, c.Loc)
+	assert.False(t, c.HasSelection())
+	b.UndoOneEvent()
+	b.Duplicate(Loc{1, 1}, Loc{1, 1})
+	assert.Equal(t, "one two\nthree\nthree", string(b.Bytes()))
+	assert.Equal(t, Loc{1, 2}, c.Loc)
+	b.UndoOneEvent()
+	assert.Equal(t, "one two\nthree", string(b.Bytes()))
+}
+
+func TestTransformCase(t *testing.T) {
+	b := NewBufferFromString("Hello World\nErnleȝe æðelen", "", BTDefault)
+	defer b.Close()
+
+	b.TransformCase(Loc{0, 0}, Loc{5, 0}, CaseUpper)
+	assert.Equal(t, "HELLO World\nErnleȝe æðelen", string(b.Bytes()))
+
+	b.TransformCase(Loc{6, 0}, Loc{11, 0}, CaseLower)
+	assert.Equal(t, "HELLO world\nErnleȝe æðelen", string(b.Bytes()))
+
+	b.TransformCase(Loc{0, 1}, Loc{14, 1}, CaseUpper)
+	assert.Equal(t, "HELLO world\nERNLEȜE ÆÐELEN", string(b.Bytes()))
+
+	b.TransformCase(Loc{3, 0}, Loc{3, 1}, CaseToggle)
+	assert.Equal(t, "HELlo WORLD\nernLEȜE ÆÐELEN", string(b.Bytes()))
+
+	// a range whose case doesn't change creates no event
+	n := b.UndoStackSize()
+	b.TransformCase(Loc{0, 0}, Loc{3, 0}, CaseUpper)
+	assert.Equal(t, n, b.UndoStackSize())
+
+	b.UndoOneEvent()
+	assert.Equal(t, "HELLO world\nERNLEȜE ÆÐELEN", string(b.Bytes()))
+	b.UndoOneEvent()
+	assert.Equal(t, "HELLO world\nErnleȝe æðelen", string(b.Bytes()))
+	b.UndoOneEvent()
+	b.UndoOneEvent()
+	assert.Equal(t, "Hello World\nErnleȝe æðelen", string(b.Bytes()))
+}
+
+func TestIndentLines(t *testing.T) {
+	b := NewBufferFromString("a\n  b\n\n\tc\n    d\n \te", "", BTDefault)
+	defer b.Close()
+	c := b.GetActiveCursor()
+	c.GotoLoc(Loc{1, 1})
+
+	b.IndentLines(0, 5, []byte("    "), false)
+	assert.Equal(t, "    a\n      b\n\n    \tc\n        d\n     \te", string(b.Bytes()))
+	assert.Equal(t, Loc{5, 1}, c.Loc)
+	assert.Equal(t, 1, b.UndoStackSize())
+
+	b.UndoOneEvent()
+	assert.Equal(t, "a\n  b\n\n\tc\n    d\n \te", string(b.Bytes()))
+
+	c.GotoLoc(Loc{1, 1})
+	b.IndentLines(0, 5, []byte("    "), true)
+	assert.Equal(t, "a\nb\n\nc\nd\ne", string(b.Bytes()))
+	assert.Equal(t, Loc{0, 1}, c.Loc)
+	assert.Equal(t, 1, b.UndoStackSize())
+
+	b.UndoOneEvent()
+	assert.Equal(t, "a\n  b\n\n\tc\n    d\n \te", string(b.Bytes()))
+
+	// only the given lines are changed
+	b.IndentLines(4, 3, []byte("\t"), false)
+	assert.Equal(t, "a\n  b\n\n\t\tc\n\t    d\n \te", string(b.Bytes()))
+}
+
+func TestEventSnapshots(t *testing.T) {
+	b := NewBufferFromString("hello world", "", BTDefault)
+	defer b.Close()
+
+	b.Insert(Loc{5, 0}, ",")
+	before, after := b.UndoStack.Peek().Snapshots()
+	assert.Nil(t, before)
+	assert.Nil(t, after)
+
+	b.RecordSnapshots = true
+	b.Insert(Loc{12, 0}, " again")
+	before, after = b.UndoStack.Peek().Snapshots()
+	assert.Equal(t, "hello, world", string(before))
+	assert.Equal(t, "hello, world again", string(after))
+	assert.Equal(t, string(after), string(before)+" again")
+}
+
+func TestReplaceRegexInRange(t *testing.T) {
+	b := NewBufferFromString("foo\nfoo\nfoofoofoo\nErnleȝe foo æðelen\n", "", BTDefault)
+	defer b.Close()
+
+	// lines 2-3 only
+	n, _ := b.ReplaceRegex(Loc{0, 1}, Loc{9, 2}, regexp.MustCompile("foo"), []byte("bar"), false)
+	assert.Equal(t, 4, n)
+	assert.Equal(t, "foo\nbar\nbarbarbar\nErnleȝe foo æðelen\n", string(b.Bytes()))
+	b.Undo()
+
+	// matches that cross the range boundary are skipped
+	n, _ = b.ReplaceRegex(Loc{2, 1}, Loc{7, 2}, regexp.MustCompile("foo"), []byte("bar"), false)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, "foo\nfoo\nbarbarfoo\nErnleȝe foo æðelen\n", string(b.Bytes()))
+
+	// capture groups expand within the range
+	n, _ = b.ReplaceRegex(Loc{0, 3}, Loc{11, 3}, regexp.MustCompile("(f)(oo)"), []byte("$2$1"), true)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, "foo\nfoo\nbarbarfoo\nErnleȝe oof æðelen\n", string(b.Bytes()))
+}
+
+func TestReplaceRegexCaptureGroups(t *testing.T) {
+	b := NewBufferFromString("foo1 foo22\nbar\nfoo333 æ\n", "", BTDefault)
+	defer b.Close()
+
+	re := regexp.MustCompile(`foo(\d+)`)
+	n, _ := b.ReplaceRegex(b.Start(), b.End(), re, []byte("bar$1"), true)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, "bar1 bar22\nbar\nbar333 æ\n", string(b.Bytes()))
+	b.Undo()
+
+	re = regexp.MustCompile(`foo(?P<num>\d+)`)
+	b.ReplaceRegex(b.Start(), b.End(), re, []byte("${num}$$"), true)
+	assert.Equal(t, "1$ 22$\nbar\n333$ æ\n", string(b.Bytes()))
+	b.Undo()
+
+	// references to missing groups expand to nothing
+	b.ReplaceRegex(b.Start(), b.End(), re, []byte("<$2${missing}>"), true)
+	assert.Equal(t, "<> <>\nbar\n<> æ\n", string(b.Bytes()))
+	b.Undo()
+
+	// partial lines at the edges of the range expand groups too
+	b.ReplaceRegex(Loc{1, 0}, Loc{6, 2}, re, []byte("[$1]"), true)
+	assert.Equal(t, "foo1 [22]\nbar\n[333] æ\n", string(b.Bytes()))
+	b.Undo()
+
+	// without captureGroups the value is literal
+	b.ReplaceRegex(b.Start(), b.End(), re, []byte("$1"), false)
+	assert.Equal(t, "$1 $1\nbar\n$1 æ\n", string(b.Bytes()))
+}
+
+func TestCountRegex(t *testing.T) {
+	b := NewBufferFromString("foo\nfoo\nfoofoofoo\nErnleȝe foo æðelen\n", "", BTDefault)
+	defer b.Close()
+
+	for _, search := range []string{"foo", `\bfoo\b`, "o*", "^", "(?i)FOO"} {
+		re := regexp.MustCompile("(?m)" + search)
+		for _, r := range [][2]Loc{{b.Start(), b.End()}, {{1, 1}, {5, 2}}} {
+			before := string(b.Bytes())
+			n := b.CountRegex(r[0], r[1], re)
+			assert.Equal(t, before, string(b.Bytes()))
+			replaced, _ := b.ReplaceRegex(r[0], r[1], re, []byte("x"), false)
+			assert.Equal(t, replaced, n, search)
+			b.Undo()
+		}
+	}
+	assert.Equal(t, 6, b.CountRegex(b.Start(), b.End(), regexp.MustCompile("foo")))
+}
+
+func TestDiffAgainstSaved(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "diff.txt")
+	if err := os.WriteFile(path, []byte("one\ntwo\nthree\nfour\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := NewBufferFromFile(path, BTDefault)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	assert.Len(t, b.DiffAgainstSaved(), 0)
+
+	b.Remove(Loc{0, 2}, Loc{0, 3})
+	assert.Equal(t, map[int]int{2: DSDeletedAbove}, b.DiffAgainstSaved())
+	b.UndoOneEvent()
+	assert.Len(t, b.DiffAgainstSaved(), 0)
+
+	b.Replace(Loc{0, 1}, Loc{3, 1}, "TWO")
+	b.Insert(Loc{0, 3}, "new\n")
+	assert.Equal(t, map[int]int{1: DSModified, 3: DSAdded}, b.DiffAgainstSaved())
+
+	// the file is not read again while it is unchanged on disk
+	saved := b.savedDiffCache.saved
+	b.Remove(Loc{0, 0}, Loc{0, 1})
+	assert.Equal(t, map[int]int{0: DSModified, 2: DSAdded}, b.DiffAgainstSaved())
+	assert.True(t, &saved[0] == &b.savedDiffCache.saved[0])
+
+	assert.NoError(t, b.Save())
+	assert.Len(t, b.DiffAgainstSaved(), 0)
+
+	s := NewBufferFromString("a\nb", "", BTDefault)
+	defer s.Close()
+	assert.Equal(t, map[int]int{0: DSAdded, 1: DSAdded}, s.DiffAgainstSaved())
+}
+
+func TestEventHandlerFromString(t *testing.T) {
+	eh, sb := NewEventHandlerFromString("hello world")
+	assert.Equal(t, 1, len(eh.cursors))
+	assert.Equal(t, Loc{0, 0}, eh.cursors[0].Loc)
+
+	eh.Insert(Loc{5, 0}, ",")
+	assert.Equal(t, "hello, world", string(sb.Bytes()))
+	assert.Equal(t, Loc{0, 0}, eh.cursors[0].Loc)
+
+	eh.Remove(Loc{0, 0}, Loc{7, 0})
+	assert.Equal(t, "world", string(sb.Bytes()))
+
+	eh.Replace(Loc{0, 0}, Loc{5, 0}, "there\nfriend")
+	assert.Equal(t, "there\nfriend", string(sb.Bytes()))
+	assert.Equal(t, 2, sb.LinesNum())
+
+	// Replace is a remove followed by an insert
+	eh.UndoOneEvent()
+	eh.UndoOneEvent()
+	assert.Equal(t, "world", string(sb.Bytes()))
+	eh.UndoOneEvent()
+	assert.Equal(t, "hello, world", string(sb.Bytes()))
+	eh.UndoOneEvent()
+	assert.Equal(t, "hello world", string(sb.Bytes()))
+	assert.Equal(t, 0, eh.UndoStack.Len())
+
+	// the replace is a group of its own, apart from the earlier edits
+	eh.Redo()
+	assert.Equal(t, "world", string(sb.Bytes()))
+	eh.Redo()
+	assert.Equal(t, "there\nfriend", string(sb.Bytes()))
+	eh.Undo()
+	assert.Equal(t, "world", string(sb.Bytes()))
+	eh.Undo()
+	assert.Equal(t, "hello world", string(sb.Bytes()))
+}
+
+func TestUndoWithDeltas(t *testing.T) {
+	eh, sb := NewEventHandlerFromString("foo")
+
+	eh.Insert(Loc{3, 0}, " bar\nbaz")
+	ok, deltas := eh.UndoWithDeltas()
+	assert.True(t, ok)
+	assert.Equal(t, "foo", string(sb.Bytes()))
+	assert.Equal(t, []Delta{{nil, Loc{3, 0}, Loc{3, 1}}}, deltas)
+
+	eh.Remove(Loc{0, 0}, Loc{2, 0})
+	eh.Insert(Loc{1, 0}, "x")
+	ok, deltas = eh.UndoWithDeltas()
+	assert.True(t, ok)
+	assert.Equal(t, "foo", string(sb.Bytes()))
+	assert.Equal(t, []Delta{
+		{nil, Loc{1, 0}, Loc{2, 0}},
+		{[]byte("fo"), Loc{0, 0}, Loc{0, 0}},
+	}, deltas)
+
+	eh.Redo()
+	ok, deltas = eh.UndoWithDeltas()
+	assert.True(t, ok)
+	assert.Len(t, deltas, 2)
+
+	ok, deltas = eh.UndoWithDeltas()
+	assert.False(t, ok)
+	assert.Len(t, deltas, 0)
+}
+
+func TestCoalesceInserts(t *testing.T) {
+	eh, sb := NewEventHandlerFromString("")
+	for i, r := range "hello" {
+		eh.Insert(Loc{i, 0}, string(r))
+	}
+	assert.Equal(t, "hello", string(sb.Bytes()))
+	assert.Equal(t, 1, eh.UndoStack.Len())
+	assert.Equal(t, Loc{5, 0}, eh.cursors[0].Loc)
+	d := eh.UndoStack.Peek().Deltas[0]
+	assert.Equal(t, Delta{[]byte("hello"), Loc{0, 0}, Loc{5, 0}}, d)
+
+	// a newline, a separate position or an old event start new events
+	eh.Insert(Loc{5, 0}, "\n")
+	eh.Insert(Loc{0, 1}, "w")
+	eh.Insert(Loc{0, 0}, "x")
+	assert.Equal(t, 4, eh.UndoStack.Len())
+	eh.UndoStack.Peek().Time = time.Now().Add(-time.Hour)
+	eh.Insert(Loc{1, 0}, "y")
+	assert.Equal(t, 5, eh.UndoStack.Len())
+
+	eh.UndoOneEvent()
+	eh.UndoOneEvent()
+	eh.UndoOneEvent()
+	assert.Equal(t, "hello\n", string(sb.Bytes()))
+	eh.UndoOneEvent()
+	eh.UndoOneEvent()
+	assert.Equal(t, "", string(sb.Bytes()))
+	eh.RedoOneEvent()
+	assert.Equal(t, "hello", string(sb.Bytes()))
+}
+
+func TestRemoveLines(t *testing.T) {
+	eh, sb := NewEventHandlerFromString("one\ntwo\nthree\nfour\nfive")
+	c := eh.cursors[0]
+
+	eh.RemoveLines(1, 2)
+	assert.Equal(t, "one\nfour\nfive", string(sb.Bytes()))
+	assert.Equal(t, Loc{0, 1}, c.Loc)
+	assert.Equal(t, 1, eh.UndoStack.Len())
+
+	eh.RemoveLine(2)
+	assert.Equal(t, "one\nfour", string(sb.Bytes()))
+	assert.Equal(t, Loc{0, 1}, c.Loc)
+
+	eh.RemoveLines(1, 0)
+	assert.Equal(t, "", string(sb.Bytes()))
+	assert.Equal(t, Loc{0, 0}, c.Loc)
+
+	eh.UndoOneEvent()
+	eh.UndoOneEvent()
+	eh.UndoOneEvent()
+	assert.Equal(t, "one\ntwo\nthree\nfour\nfive", string(sb.Bytes()))
+}
+
+func TestTextEventString(t *testing.T) {
+	eh, _ := NewEventHandlerFromString("foo\nbar")
+
+	eh.Insert(Loc{3, 1}, "\tbaz")
+	e := eh.UndoStack.Peek()
+	assert.Equal(t, "insert", e.TypeString())
+	assert.Equal(t, `insert (3,1)-(7,1) "\tbaz"`, e.String())
+
+	eh.Remove(Loc{1, 0}, Loc{1, 1})
+	e = eh.UndoStack.Peek()
+	assert.Equal(t, "remove", e.TypeString())
+	assert.Equal(t, `remove (1,0)-(1,1) "oo\nb"`, e.String())
+
+	eh.MultipleReplace([]Delta{
+		{[]byte("x"), Loc{0, 0}, Loc{1, 0}},
+		{[]byte(strings.Repeat("é", 25)), Loc{2, 0}, Loc{3, 0}},
+	})
+	e = eh.UndoStack.Peek()
+	assert.Equal(t, "replace", e.TypeString())
+	assert.Equal(t, `replace (2,0)-(27,0) "r", (0,0)-(1,0) "f"`, e.String())
+
+	eh.Insert(Loc{0, 0}, strings.Repeat("é", 25))
+	assert.Equal(t, `insert (0,0)-(25,0) "`+strings.Repeat("é", 20)+`"...`, eh.UndoStack.Peek().String())
+
+	e.EventType = 5
+	assert.Equal(t, "unknown(5)", e.TypeString())
+}
+
+func TestStaleActiveCursor(t *testing.T) {
+	b := NewBufferFromString("foo\nbar\nbaz", "", BTDefault)
+	defer b.Close()
+	b.AddCursor(NewCursor(b, Loc{0, 1}))
+	b.AddCursor(NewCursor(b, Loc{0, 2}))
+	b.SetCurCursor(2)
+	b.UpdateCursors()
+
+	b.ClearCursors()
+	b.Insert(Loc{0, 0}, "x")
+	assert.Equal(t, "xfoo\nbar\nbaz", string(b.Bytes()))
+
+	// another buffer sharing the event handler removed the active cursor
+	b.EventHandler.active = 4
+	b.Remove(Loc{0, 0}, Loc{1, 0})
+	assert.Equal(t, "foo\nbar\nbaz", string(b.Bytes()))
+	assert.Equal(t, 0, b.UndoStack.Peek().C.Num)
+
+	assert.Error(t, b.SetActiveCursor(1))
+	assert.Error(t, b.SetActiveCursor(-1))
+	assert.NoError(t, b.SetActiveCursor(0))
+}
+
+func TestStripTrailingWhitespace(t *testing.T) {
+	orig := "foo  \n\tbar\t\n \nbaz\nqux \t"
+	eh, sb := NewEventHandlerFromString(orig)
+	c := eh.cursors[0]
+	c.GotoLoc(Loc{5, 0})
+
+	eh.StripTrailingWhitespace()
+	assert.Equal(t, "foo\n\tbar\n\nbaz\nqux", string(sb.Bytes()))
+	assert.Equal(t, Loc{3, 0}, c.Loc)
+	assert.Equal(t, 1, eh.UndoStack.Len())
+
+	eh.UndoOneEvent()
+	assert.Equal(t, orig, string(sb.Bytes()))
+	assert.Equal(t, 0, eh.UndoStack.Len())
+
+	// the whitespace the cursor is typing is kept
+	c.NewTrailingWsY = 4
+	eh.StripTrailingWhitespace()
+	assert.Equal(t, "foo\n\tbar\n\nbaz\nqux \t", string(sb.Bytes()))
+
+	eh.StripTrailingWhitespace()
+	assert.Equal(t, 1, eh.UndoStack.Len())
+}
+
+func TestGotoLoc(t *testing.T) {
+	eh, _ := NewEventHandlerFromString("one\ntwo\nthree")
+	c := eh.cursors[0]
+
+	assert.NoError(t, eh.GotoLoc(Loc{2, 1}))
+	assert.Equal(t, Loc{2, 1}, c.Loc)
+	assert.NoError(t, eh.GotoLoc(Loc{5, 2}))
+	assert.Equal(t, Loc{5, 2}, c.Loc)
+
+	err := eh.GotoLoc(Loc{10, 0})
+	assert.True(t, errors.Is(err, ErrLocOutOfRange))
+	assert.Equal(t, Loc{3, 0}, c.Loc)
+
+	err = eh.GotoLoc(Loc{0, 7})
+	assert.EqualError(t, err, "location out of range: 8:1 moved to 3:6")
+	assert.Equal(t, Loc{5, 2}, c.Loc)
+
+	c.SetSelectionStart(Loc{0, 0})
+	c.SetSelectionEnd(Loc{2, 0})
+	assert.Error(t, eh.GotoLoc(Loc{-1, -1}))
+	assert.Equal(t, Loc{0, 0}, c.Loc)
+	assert.False(t, c.HasSelection())
+}
+
+func TestMacroReplay(t *testing.T) {
+	eh, sb := NewEventHandlerFromString("one\ntwo\nthree")
+	c := eh.cursors[0]
+
+	assert.NoError(t, eh.GotoLoc(Loc{1, 0}))
+	eh.StartRecording()
+	assert.True(t, eh.Recording())
+	eh.Insert(c.Loc, "<")
+	eh.Insert(Loc{3, 0}, ">")
+	events := eh.StopRecording()
+	assert.False(t, eh.Recording())
+	eh.Insert(eh.buf.End(), "!")
+
+	assert.Len(t, events, 2)
+	assert.Equal(t, "o<n>e\ntwo\nthree!", string(sb.Bytes()))
+
+	assert.NoError(t, eh.GotoLoc(Loc{2, 2}))
+	undos := eh.UndoStack.Len()
+	eh.Replay(events)
+	assert.Equal(t, "o<n>e\ntwo\nth<r>ee!", string(sb.Bytes()))
+
+	eh.Undo()
+	assert.Equal(t, undos, eh.UndoStack.Len())
+	assert.Equal(t, "o<n>e\ntwo\nthree!", string(sb.Bytes()))
+}
+
+func TestInsertFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "readin.txt")
+	if err := os.WriteFile(path, []byte("foo\r\nbar"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	eh, sb := NewEventHandlerFromString("ab\ncd")
+	undos := eh.UndoStack.Len()
+	assert.NoError(t, eh.InsertFile(Loc{1, 0}, path))
+	assert.Equal(t, "afoo\nbarb\ncd", string(sb.Bytes()))
+	assert.Equal(t, undos+1, eh.UndoStack.Len())
+
+	eh.UndoOneEvent()
+	assert.Equal(t, "ab\ncd", string(sb.Bytes()))
+
+	assert.Error(t, eh.InsertFile(Loc{0, 0}, filepath.Join(t.TempDir(), "missing")))
+	assert.Equal(t, undos, eh.UndoStack.Len())
+}
+
+func TestSortLines(t *testing.T) {
+	tests := []struct {
+		reverse, caseInsensitive, numeric bool
+		want                              string
+	}{
+		{false, false, false, "10 pears\n2 Apples\n2 apples\nBanana\ncherry\n"},
+		{true, false, false, "cherry\nBanana\n2 apples\n2 Apples\n10 pears\n"},
+		{false, true, false, "10 pears\n2 apples\n2 Apples\nBanana\ncherry\n"},
+		{false, false, true, "2 Apples\n2 apples\n10 pears\nBanana\ncherry\n"},
+		{true, false, true, "cherry\nBanana\n10 pears\n2 apples\n2 Apples\n"},
+	}
+	for _, tt := range tests {
+		eh, sb := NewEventHandlerFromString("cherry\n2 apples\nBanana\n10 pears\n2 Apples\n")
+		undos := eh.UndoStack.Len()
+		eh.SortLines(0, 5, tt.reverse, tt.caseInsensitive, tt.numeric)
+		assert.Equal(t, tt.want, string(sb.Bytes()))
+		assert.Equal(t, undos+1, eh.UndoStack.Len())
+
+		eh.UndoOneEvent()
+		assert.Equal(t, "cherry\n2 apples\nBanana\n10 pears\n2 Apples\n", string(sb.Bytes()))
+	}
+
+	eh, sb := NewEventHandlerFromString("c\nb\na\nz")
+	eh.cursors[0].GotoLoc(Loc{1, 1})
+	eh.SortLines(0, 2, false, false, false)
+	assert.Equal(t, "a\nb\nc\nz", string(sb.Bytes()))
+	assert.Equal(t, Loc{1, 1}, eh.cursors[0].Loc)
+}
+
+func TestJoinLines(t *testing.T) {
+	tests := []struct {
+		text         string
+		startY, endY int
+		want         string
+		cursor       Loc
+	}{
+		{"foo  \n   bar\nbaz", 0, 0, "foo bar\nbaz", Loc{3, 0}},
+		{"foo\n\tbar  \n  baz\nqux", 0, 2, "foo bar baz\nqux", Loc{7, 0}},
+		{"foo\nbar", 0, 1, "foo bar", Loc{3, 0}},
+		{"foo\n\nbar\n", 0, 2, "foo bar\n", Loc{3, 0}},
+		{"\n  \nbar", 0, 2, "bar", Loc{0, 0}},
+		{"foo\n", 0, 1, "foo", Loc{3, 0}},
+	}
+	for _, tt := range tests {
+		eh, sb := NewEventHandlerFromString(tt.text)
+		undos := eh.UndoStack.Len()
+		eh.JoinLines(tt.startY, tt.endY)
+		assert.Equal(t, tt.want, string(sb.Bytes()), tt.text)
+		assert.Equal(t, tt.cursor, eh.cursors[0].Loc, tt.text)
+		assert.Equal(t, undos+1, eh.UndoStack.Len())
+
+		eh.UndoOneEvent()
+		assert.Equal(t, tt.text, string(sb.Bytes()))
+	}
+
+	eh, sb := NewEventHandlerFromString("foo\nbar")
+	eh.JoinLines(1, 1)
+	assert.Equal(t, "foo\nbar", string(sb.Bytes()))
+	assert.Equal(t, 0, eh.UndoStack.Len())
+}
+
+func TestApplyDiffStats(t *testing.T) {
+	eh, sb := NewEventHandlerFromString("one\ntwo\nthree\nfour\n")
+	added, removed := eh.ApplyDiffStats("one\n2\nthree\nfour\nfive\nsix")
+	assert.Equal(t, "one\n2\nthree\nfour\nfive\nsix", string(sb.Bytes()))
+	assert.Equal(t, 3, added)
+	assert.Equal(t, 1, removed)
+
+	// the same events as ApplyDiff are executed
+	other, _ := NewEventHandlerFromString("one\ntwo\nthree\nfour\n")
+	other.ApplyDiff("one\n2\nthree\nfour\nfive\nsix")
+	assert.Equal(t, other.UndoStack.Len(), eh.UndoStack.Len())
+
+	added, removed = eh.ApplyDiffStats("one\n2\nthree\nfour\nfive\nsix")
+	assert.Equal(t, 0, added)
+	assert.Equal(t, 0, removed)
+
+	added, removed = eh.ApplyDiffStats("one\n2\nthree!\nfour\nfive\nsix")
+	assert.Equal(t, 1, added)
+	assert.Equal(t, 0, removed)
+
+	added, removed = eh.ApplyDiffStats("")
+	assert.Equal(t, "", string(sb.Bytes()))
+	assert.Equal(t, 0, added)
+	assert.Equal(t, 6, removed)
+}
+
+func TestInsertVirtual(t *testing.T) {
+	eh, sb := NewEventHandlerFromString("long line\nab\n\nabcdef")
+	sb.Settings["undothreshold"] = float64(0)
+	for y := 0; y < 4; y++ {
+		eh.InsertVirtual(Loc{6, y}, "|")
+	}
+	assert.Equal(t, "long l|ine\nab    |\n      |\nabcdef|", string(sb.Bytes()))
+	assert.Equal(t, 4, eh.UndoStack.Len())
+
+	eh.UndoOneEvent()
+	eh.UndoOneEvent()
+	assert.Equal(t, "long l|ine\nab    |\n\nabcdef", string(sb.Bytes()))
+}
+
+func TestEventHandlerConcurrent(t *testing.T) {
+	eh, sb := NewEventHandlerFromString("")
+	const writers, inserts = 4, 50
+
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < inserts; j++ {
+				eh.Insert(Loc{0, 0}, "x")
+			}
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			assert.LessOrEqual(t, eh.UndoStackSize(), writers*inserts)
+			eh.CanUndo()
+			eh.RedoStackSize()
+			eh.StripTrailingWhitespace()
+			eh.GotoLoc(Loc{i, 0})
+		}
+	}()
+	wg.Wait()
+	<-done
+
+	assert.Equal(t, strings.Repeat("x", writers*inserts), string(sb.Bytes()))
+	for eh.Undo() {
+	}
+	assert.Equal(t, "", string(sb.Bytes()))
+	assert.Equal(t, 0, eh.UndoStackSize())
+}
+
+func TestReplaceSingleUndo(t *testing.T) {
+	eh, sb := NewEventHandlerFromString("foo bar baz")
+	sb.Settings["undothreshold"] = float64(0)
+	eh.Replace(Loc{4, 0}, Loc{7, 0}, "BAR")
+	assert.Equal(t, "foo BAR baz", string(sb.Bytes()))
+
+	assert.True(t, eh.Undo())
+	assert.Equal(t, "foo bar baz", string(sb.Bytes()))
+	assert.False(t, eh.CanUndo())
+	assert.True(t, eh.Redo())
+	assert.Equal(t, "foo BAR baz", string(sb.Bytes()))
+}
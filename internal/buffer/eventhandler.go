@@ -1,11 +1,23 @@
 package buffer
 
 import (
+	"bufio"
 	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
+	"unicode"
 
 	dmp "github.com/sergi/go-diff/diffmatchpatch"
+	"github.com/zyedidia/micro/v2/internal/config"
 	"github.com/zyedidia/micro/v2/internal/util"
+	"golang.org/x/text/transform"
 )
 
 const (
@@ -18,7 +30,9 @@ const (
 	// TextEventReplace represents a replace event
 	TextEventReplace = 0
 
-	undoThreshold = 1000 // If two events are less than n milliseconds apart, undo both of them
+	// If two events are less than n milliseconds apart, undo both of them.
+	// Used when the buffer has no undothreshold setting
+	defaultUndoThreshold = 1000
 )
 
 // TextEvent holds data for a manipulation on some text that can be undone
@@ -28,6 +42,58 @@ type TextEvent struct {
 	EventType int
 	Deltas    []Delta
 	Time      time.Time
+	// Events with the same non-zero Group are undone and redone together
+	Group int
+
+	// the buffer's bytes before and after the event was executed, recorded
+	// when the EventHandler's RecordSnapshots is set
+	before, after []byte
+}
+
+// Snapshots returns the bytes of the buffer from just before and just after
+// the event was first executed. Both are nil unless the event was executed
+// by an EventHandler with RecordSnapshots set
+func (t *TextEvent) Snapshots() (before, after []byte) {
+	return t.before, t.after
+}
+
+// TypeString returns the name of the event type: "insert", "remove" or
+// "replace"
+func (t *TextEvent) TypeString() string {
+	switch t.EventType {
+	case TextEventInsert:
+		return "insert"
+	case TextEventRemove:
+		return "remove"
+	case TextEventReplace:
+		return "replace"
+	}
+	return fmt.Sprintf("unknown(%d)", t.EventType)
+}
+
+// maxEventStringText is the number of characters of each delta's text shown
+// by TextEvent.String
+const maxEventStringText = 20
+
+// String returns a summary of the event for logs and error reports: its type
+// followed by the start, end and text of every delta, with long texts
+// truncated
+func (t *TextEvent) String() string {
+	var sb strings.Builder
+	sb.WriteString(t.TypeString())
+	for i, d := range t.Deltas {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		text := []rune(string(d.Text))
+		suffix := ""
+		if len(text) > maxEventStringText {
+			text = text[:maxEventStringText]
+			suffix = "..."
+		}
+		fmt.Fprintf(&sb, " (%d,%d)-(%d,%d) %q%s", d.Start.X, d.Start.Y, d.End.X, d.End.Y, string(text), suffix)
+	}
+	return sb.String()
 }
 
 // A Delta is a change to the buffer
@@ -39,10 +105,24 @@ type Delta struct {
 
 // DoTextEvent runs a text event
 func (eh *EventHandler) DoTextEvent(t *TextEvent, useUndo bool) {
+	eh.mu.Lock()
+	defer eh.mu.Unlock()
+	eh.doTextEvent(t, useUndo)
+}
+
+// doTextEvent is DoTextEvent without locking the event handler
+func (eh *EventHandler) doTextEvent(t *TextEvent, useUndo bool) {
+	if eh.OnChange != nil {
+		defer eh.notifyChange(t, replaceDeltas(t))
+	}
+	if eh.collecting {
+		defer eh.collectDeltas(t, replaceDeltas(t))
+	}
+
 	oldl := eh.buf.LinesNum()
 
 	if useUndo {
-		eh.Execute(t)
+		eh.execute(t)
 	} else {
 		ExecuteTextEvent(t, eh.buf)
 	}
@@ -108,6 +188,44 @@ func (eh *EventHandler) DoTextEvent(t *TextEvent, useUndo bool) {
 	}
 }
 
+// replaceDeltas returns a copy of the deltas of a replace event, which
+// describe the replacement only before the event is executed
+func replaceDeltas(t *TextEvent) []Delta {
+	if t.EventType != TextEventReplace {
+		return nil
+	}
+	return append([]Delta(nil), t.Deltas...)
+}
+
+// notifyChange calls OnChange for an executed event. For replace events,
+// deltas holds the deltas from before execution
+func (eh *EventHandler) notifyChange(t *TextEvent, deltas []Delta) {
+	if deltas != nil {
+		c := *t
+		c.Deltas = deltas
+		t = &c
+	}
+	eh.OnChange(t)
+}
+
+// collectDeltas records the changes made by an executed event as deltas
+// that replace the text from Start to End with Text. For replace events,
+// deltas holds the deltas from before execution
+func (eh *EventHandler) collectDeltas(t *TextEvent, deltas []Delta) {
+	switch t.EventType {
+	case TextEventInsert:
+		for _, d := range t.Deltas {
+			eh.collected = append(eh.collected, Delta{d.Text, d.Start, d.Start})
+		}
+	case TextEventRemove:
+		for _, d := range t.Deltas {
+			eh.collected = append(eh.collected, Delta{nil, d.Start, d.End})
+		}
+	case TextEventReplace:
+		eh.collected = append(eh.collected, deltas...)
+	}
+}
+
 // ExecuteTextEvent runs a text event
 func ExecuteTextEvent(t *TextEvent, buf *SharedBuffer) {
 	if t.EventType == TextEventInsert {
@@ -123,7 +241,7 @@ func ExecuteTextEvent(t *TextEvent, buf *SharedBuffer) {
 			t.Deltas[i].Text = buf.remove(d.Start, d.End)
 			buf.insert(d.Start, d.Text)
 			t.Deltas[i].Start = d.Start
-			t.Deltas[i].End = Loc{d.Start.X + util.CharacterCount(d.Text), d.Start.Y}
+			t.Deltas[i].End = textEnd(d.Start, d.Text)
 		}
 		for i, j := 0, len(t.Deltas)-1; i < j; i, j = i+1, j-1 {
 			t.Deltas[i], t.Deltas[j] = t.Deltas[j], t.Deltas[i]
@@ -131,19 +249,76 @@ func ExecuteTextEvent(t *TextEvent, buf *SharedBuffer) {
 	}
 }
 
+// textEnd returns the location of the end of text inserted at start
+func textEnd(start Loc, text []byte) Loc {
+	if lastnl := bytes.LastIndexByte(text, '\n'); lastnl >= 0 {
+		return Loc{util.CharacterCount(text[lastnl+1:]), start.Y + bytes.Count(text, []byte{'\n'})}
+	}
+	return Loc{start.X + util.CharacterCount(text), start.Y}
+}
+
 // UndoTextEvent undoes a text event
 func (eh *EventHandler) UndoTextEvent(t *TextEvent) {
+	eh.mu.Lock()
+	defer eh.mu.Unlock()
+	eh.undoTextEvent(t)
+}
+
+// undoTextEvent is UndoTextEvent without locking the event handler
+func (eh *EventHandler) undoTextEvent(t *TextEvent) {
 	t.EventType = -t.EventType
-	eh.DoTextEvent(t, false)
+	eh.doTextEvent(t, false)
 }
 
-// EventHandler executes text manipulations and allows undoing and redoing
+// EventHandler executes text manipulations and allows undoing and redoing.
+// Its methods lock it for their whole operation, so they are safe to call
+// from several goroutines at once. Other goroutines must use these methods
+// rather than read the stacks or the cursors directly while the buffer may
+// be edited
 type EventHandler struct {
+	mu sync.RWMutex
+
 	buf       *SharedBuffer
 	cursors   []*Cursor
 	active    int
 	UndoStack *TEStack
 	RedoStack *TEStack
+
+	// MaxUndoEvents is the maximum number of events kept in the undo
+	// stack, 0 means unlimited
+	MaxUndoEvents int
+	// MaxUndoBytes is the maximum total size of the text held by the
+	// events in the undo stack, 0 means unlimited
+	MaxUndoBytes int
+
+	// OnChange is called after every text event is executed, including
+	// undos and redos, which are passed with their effective event type.
+	// Replace events are passed with the deltas they were executed with,
+	// applied in order. It may be called while the event handler is locked,
+	// so it must not execute, undo or redo events or query the stack sizes
+	OnChange func(t *TextEvent)
+
+	// RecordSnapshots makes every executed event keep a copy of the whole
+	// buffer from before and after it, see TextEvent.Snapshots. This uses a
+	// lot of memory and is meant for integrations that need a consistent
+	// view of the text at each change
+	RecordSnapshots bool
+
+	// current node of the undo tree, nil if it must be rebuilt
+	tree *undoNode
+
+	// group given to executed events, and the nesting depth of undo groups
+	group      int
+	groupDepth int
+
+	// deltas applied to the buffer while collecting, see UndoWithDeltas
+	collecting bool
+	collected  []Delta
+
+	// events executed while recording a macro, see StartRecording
+	recording    bool
+	recorded     []TextEvent
+	recordOrigin Loc
 }
 
 // NewEventHandler returns a new EventHandler
@@ -156,26 +331,250 @@ func NewEventHandler(buf *SharedBuffer, cursors []*Cursor) *EventHandler {
 	return eh
 }
 
+// NewEventHandlerFromString returns an event handler for an in-memory buffer
+// holding s, with a single cursor at the start of the buffer. The buffer uses
+// the default settings, has no syntax highlighting and is not added to
+// OpenBuffers, so it can be used in tests and by embedders without starting
+// the editor
+func NewEventHandlerFromString(s string) (*EventHandler, *SharedBuffer) {
+	sb := new(SharedBuffer)
+	sb.Type = BTScratch
+	sb.Settings = config.DefaultCommonSettings()
+	sb.LocalSettings = make(map[string]bool)
+	sb.LineArray = NewLineArray(uint64(len(s)), FFAuto, strings.NewReader(s))
+
+	b := &Buffer{SharedBuffer: sb}
+	b.EventHandler = NewEventHandler(sb, nil)
+	b.AddCursor(NewCursor(b, Loc{0, 0}))
+	return b.EventHandler, sb
+}
+
+// activeCursor returns the active cursor. If the active index is stale,
+// for example because cursors were removed through another buffer sharing
+// this event handler, the nearest valid cursor is used instead
+func (eh *EventHandler) activeCursor() *Cursor {
+	eh.active = util.Clamp(eh.active, 0, len(eh.cursors)-1)
+	return eh.cursors[eh.active]
+}
+
+// validLoc returns the closest location to l that is inside the buffer
+func (eh *EventHandler) validLoc(l Loc) Loc {
+	l = clamp(l, eh.buf.LineArray)
+	l.X = util.Clamp(l.X, 0, util.CharacterCount(eh.buf.LineBytes(l.Y)))
+	return l
+}
+
+// ErrLocOutOfRange is returned by GotoLoc when the location had to be
+// moved into the buffer
+var ErrLocOutOfRange = errors.New("location out of range")
+
+// GotoLoc moves the active cursor to l and removes its selection. If l is
+// outside the buffer the cursor goes to the closest valid location instead,
+// and an error wrapping ErrLocOutOfRange is returned
+func (eh *EventHandler) GotoLoc(l Loc) error {
+	eh.mu.Lock()
+	defer eh.mu.Unlock()
+	loc := eh.validLoc(l)
+	c := eh.activeCursor()
+	c.ResetSelection()
+	c.GotoLoc(loc)
+	if loc != l {
+		return fmt.Errorf("%w: %d:%d moved to %d:%d", ErrLocOutOfRange, l.Y+1, l.X+1, loc.Y+1, loc.X+1)
+	}
+	return nil
+}
+
+// SetActiveCursor sets the index of the cursor whose position is recorded
+// with new events and which is moved by edits such as MoveText. It returns
+// an error if there is no cursor with that index
+func (eh *EventHandler) SetActiveCursor(i int) error {
+	eh.mu.Lock()
+	defer eh.mu.Unlock()
+	if i < 0 || i >= len(eh.cursors) {
+		return fmt.Errorf("cursor %d out of range, there are %d cursors", i, len(eh.cursors))
+	}
+	eh.active = i
+	return nil
+}
+
 // ApplyDiff takes a string and runs the necessary insertion and deletion events to make
 // the buffer equal to that string
 // This means that we can transform the buffer into any string and still preserve undo/redo
 // through insert and delete events
 func (eh *EventHandler) ApplyDiff(new string) {
+	eh.ApplyDiffMode(new, false)
+}
+
+// ApplyDiffMode is like ApplyDiff, but if wordMode is true the diff is
+// computed between whole words instead of characters. This produces fewer,
+// coarser events when large parts of the text change
+func (eh *EventHandler) ApplyDiffMode(new string, wordMode bool) {
+	eh.mu.Lock()
+	defer eh.mu.Unlock()
 	differ := dmp.New()
+	old := string(eh.buf.Bytes())
+	if wordMode {
+		r1, r2, words := wordsToRunes(old, new)
+		eh.applyDiffs(runesToWords(differ.DiffMainRunes(r1, r2, false), words))
+	} else {
+		eh.applyDiffs(differ.DiffMain(old, new, false))
+	}
+}
+
+// ApplyDiffWithTimeout is like ApplyDiff, but gives up computing the diff
+// after d and replaces the whole buffer with a single event instead. The
+// result is the same, only the undo history is coarser. A zero duration
+// means no timeout
+func (eh *EventHandler) ApplyDiffWithTimeout(new string, d time.Duration) {
+	eh.mu.Lock()
+	defer eh.mu.Unlock()
+	differ := dmp.New()
+	differ.DiffTimeout = d
+
+	start := time.Now()
 	diff := differ.DiffMain(string(eh.buf.Bytes()), new, false)
+	if d > 0 && time.Since(start) >= d {
+		eh.multipleReplace([]Delta{{[]byte(new), eh.buf.Start(), eh.buf.End()}})
+		return
+	}
+	eh.applyDiffs(diff)
+}
+
+// ApplyDiffStats is like ApplyDiff and also returns the number of lines of
+// the new text with inserted characters and of the old text with removed
+// characters, as in "N insertions, M deletions". A changed line counts as
+// both removed and inserted
+func (eh *EventHandler) ApplyDiffStats(new string) (added, removed int) {
+	eh.mu.Lock()
+	defer eh.mu.Unlock()
+	diff := dmp.New().DiffMain(string(eh.buf.Bytes()), new, false)
+
+	addedLines := make(map[int]bool)
+	removedLines := make(map[int]bool)
+	oldY, newY := 0, 0
+	for _, d := range diff {
+		n := strings.Count(d.Text, "\n")
+		switch d.Type {
+		case dmp.DiffInsert:
+			markLines(addedLines, newY, d.Text)
+			newY += n
+		case dmp.DiffDelete:
+			markLines(removedLines, oldY, d.Text)
+			oldY += n
+		default:
+			oldY += n
+			newY += n
+		}
+	}
+	eh.applyDiffs(diff)
+	return len(addedLines), len(removedLines)
+}
+
+// markLines marks the lines spanned by text starting on line y. The newline
+// that ends a line belongs to that line only
+func markLines(lines map[int]bool, y int, text string) {
+	last := y + strings.Count(text, "\n")
+	if strings.HasSuffix(text, "\n") {
+		last--
+	}
+	for ; y <= last; y++ {
+		lines[y] = true
+	}
+}
+
+// applyDiffs runs the insertion and deletion events described by a diff
+// against the current buffer contents
+func (eh *EventHandler) applyDiffs(diff []dmp.Diff) {
 	loc := eh.buf.Start()
 	for _, d := range diff {
 		if d.Type == dmp.DiffDelete {
-			eh.Remove(loc, loc.MoveLA(util.CharacterCountInString(d.Text), eh.buf.LineArray))
+			eh.removeText(loc, loc.MoveLA(util.CharacterCountInString(d.Text), eh.buf.LineArray))
 		} else {
 			if d.Type == dmp.DiffInsert {
-				eh.Insert(loc, d.Text)
+				eh.insertBytes(loc, []byte(d.Text))
 			}
 			loc = loc.MoveLA(util.CharacterCountInString(d.Text), eh.buf.LineArray)
 		}
 	}
 }
 
+// splitWords splits a string into runs of word characters, runs of
+// whitespace and single other characters. Every newline is its own token
+func splitWords(s string) []string {
+	class := func(r rune) int {
+		switch {
+		case r == '\n':
+			return 0
+		case unicode.IsSpace(r):
+			return 1
+		case util.IsWordChar(r):
+			return 2
+		}
+		return 3
+	}
+
+	var words []string
+	start, prev := 0, -1
+	for i, r := range s {
+		c := class(r)
+		if i > start && (c != prev || c == 0 || c == 3) {
+			words = append(words, s[start:i])
+			start = i
+		}
+		prev = c
+	}
+	if start < len(s) {
+		words = append(words, s[start:])
+	}
+	return words
+}
+
+// wordRune returns the rune standing for the i-th distinct word, skipping
+// the surrogate range which cannot be encoded in a string
+func wordRune(i int) rune {
+	if i >= 0xD800 {
+		i += 0x800
+	}
+	return rune(i)
+}
+
+// wordsToRunes encodes two texts as rune slices in which every rune stands
+// for a word, so that they can be diffed word by word. The returned slice
+// maps the rune values back to the words
+func wordsToRunes(text1, text2 string) ([]rune, []rune, []string) {
+	var words []string
+	index := make(map[string]rune)
+	encode := func(s string) []rune {
+		var runes []rune
+		for _, w := range splitWords(s) {
+			r, ok := index[w]
+			if !ok {
+				r = wordRune(len(words))
+				index[w] = r
+				words = append(words, w)
+			}
+			runes = append(runes, r)
+		}
+		return runes
+	}
+	return encode(text1), encode(text2), words
+}
+
+// runesToWords decodes a diff of texts encoded by wordsToRunes
+func runesToWords(diff []dmp.Diff, words []string) []dmp.Diff {
+	for i, d := range diff {
+		var b strings.Builder
+		for _, r := range d.Text {
+			if r >= 0xD800 {
+				r -= 0x800
+			}
+			b.WriteString(words[r])
+		}
+		diff[i].Text = b.String()
+	}
+	return diff
+}
+
 // Insert creates an insert text event and executes it
 func (eh *EventHandler) Insert(start Loc, textStr string) {
 	text := []byte(textStr)
@@ -184,75 +583,843 @@ func (eh *EventHandler) Insert(start Loc, textStr string) {
 
 // InsertBytes creates an insert text event and executes it
 func (eh *EventHandler) InsertBytes(start Loc, text []byte) {
+	eh.mu.Lock()
+	defer eh.mu.Unlock()
+	eh.insertBytes(start, text)
+}
+
+// insertBytes is InsertBytes without locking the event handler
+func (eh *EventHandler) insertBytes(start Loc, text []byte) {
 	if len(text) == 0 {
 		return
 	}
 	start = clamp(start, eh.buf.LineArray)
 	e := &TextEvent{
-		C:         *eh.cursors[eh.active],
+		C:         *eh.activeCursor(),
 		EventType: TextEventInsert,
 		Deltas:    []Delta{{text, start, Loc{0, 0}}},
 		Time:      time.Now(),
 	}
-	eh.DoTextEvent(e, true)
+	eh.doTextEvent(e, true)
+}
+
+// InsertAt creates an insert text event at start and executes it. If start
+// is past the end of its line, the text is inserted at the end of the line,
+// and if padSpaces is true the gap up to start.X is first filled with spaces
+// as part of the same event
+func (eh *EventHandler) InsertAt(start Loc, text string, padSpaces bool) {
+	eh.mu.Lock()
+	defer eh.mu.Unlock()
+	eh.insertAt(start, text, padSpaces)
+}
+
+// insertAt is InsertAt without locking the event handler
+func (eh *EventHandler) insertAt(start Loc, text string, padSpaces bool) {
+	start.Y = util.Clamp(start.Y, 0, eh.buf.LinesNum()-1)
+	if start.X < 0 {
+		start.X = 0
+	}
+	if n := util.CharacterCount(eh.buf.LineBytes(start.Y)); start.X > n {
+		if padSpaces && text != "" {
+			text = strings.Repeat(" ", start.X-n) + text
+		}
+		start.X = n
+	}
+	eh.insertBytes(start, []byte(text))
+}
+
+// InsertFile inserts the contents of the file at path at start as a single
+// insert event. The file is decoded with the buffer's encoding, unless it
+// starts with a byte order mark, and its line endings are converted to the
+// buffer's file format like the rest of its lines
+func (eh *EventHandler) InsertFile(start Loc, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	br := bufio.NewReader(file)
+	var r io.Reader = br
+	enc := eh.buf.encoding
+	if _, bomEnc := detectBOM(br); bomEnc != nil {
+		enc = bomEnc
+	}
+	if enc != nil {
+		r = transform.NewReader(br, enc.NewDecoder())
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	eh.mu.Lock()
+	defer eh.mu.Unlock()
+	eh.insertBytes(eh.validLoc(start), data)
+	return nil
+}
+
+// InsertVirtual inserts text at l as if the line extended with spaces past
+// its end, for column and block editing. A line shorter than l.X is padded
+// with spaces up to it in the same undoable event
+func (eh *EventHandler) InsertVirtual(l Loc, text string) {
+	eh.InsertAt(l, text, true)
 }
 
 // Remove creates a remove text event and executes it
 func (eh *EventHandler) Remove(start, end Loc) {
+	eh.mu.Lock()
+	defer eh.mu.Unlock()
+	eh.removeText(start, end)
+}
+
+// removeText is Remove without locking the event handler
+func (eh *EventHandler) removeText(start, end Loc) {
 	if start == end {
 		return
 	}
 	start = clamp(start, eh.buf.LineArray)
 	end = clamp(end, eh.buf.LineArray)
 	e := &TextEvent{
-		C:         *eh.cursors[eh.active],
+		C:         *eh.activeCursor(),
 		EventType: TextEventRemove,
 		Deltas:    []Delta{{[]byte{}, start, end}},
 		Time:      time.Now(),
 	}
-	eh.DoTextEvent(e, true)
+	eh.doTextEvent(e, true)
 }
 
 // MultipleReplace creates an multiple insertions executes them
 func (eh *EventHandler) MultipleReplace(deltas []Delta) {
+	eh.mu.Lock()
+	defer eh.mu.Unlock()
+	eh.multipleReplace(deltas)
+}
+
+// multipleReplace is MultipleReplace without locking the event handler
+func (eh *EventHandler) multipleReplace(deltas []Delta) {
 	e := &TextEvent{
-		C:         *eh.cursors[eh.active],
+		C:         *eh.activeCursor(),
 		EventType: TextEventReplace,
 		Deltas:    deltas,
 		Time:      time.Now(),
 	}
-	eh.Execute(e)
+	if eh.OnChange != nil {
+		defer eh.notifyChange(e, replaceDeltas(e))
+	}
+	eh.execute(e)
+}
+
+// MoveText moves the text between start and end to dest as a single
+// undoable replace event. Nothing happens if dest lies inside the range.
+// Afterwards the active cursor selects the text at its new location
+func (eh *EventHandler) MoveText(start, end, dest Loc) {
+	eh.mu.Lock()
+	defer eh.mu.Unlock()
+	la := eh.buf.LineArray
+	if end.LessThan(start) {
+		start, end = end, start
+	}
+	start, end, dest = clamp(start, la), clamp(end, la), clamp(dest, la)
+	if start == end || (dest.GreaterEqual(start) && dest.LessEqual(end)) {
+		return
+	}
+
+	moved := la.Substr(start, end)
+	size := DiffLA(start, end, la)
+	var from, to Loc
+	var text []byte
+	var offset int
+	if dest.LessThan(start) {
+		from, to = dest, end
+		text = append(moved, la.Substr(dest, start)...)
+	} else {
+		from, to = start, dest
+		text = append(la.Substr(end, dest), moved...)
+		offset = DiffLA(end, dest, la)
+	}
+	eh.multipleReplace([]Delta{{text, from, to}})
+
+	newStart := from.MoveLA(offset, eh.buf.LineArray)
+	newEnd := newStart.MoveLA(size, eh.buf.LineArray)
+	c := eh.activeCursor()
+	c.SetSelectionStart(newStart)
+	c.SetSelectionEnd(newEnd)
+	c.OrigSelection = c.CurSelection
+	c.Loc = newEnd
+	c.StoreVisualX()
 }
 
-// Replace deletes from start to end and replaces it with the given string
+// RemoveLine removes line y with its newline, see RemoveLines
+func (eh *EventHandler) RemoveLine(y int) {
+	eh.RemoveLines(y, y)
+}
+
+// RemoveLines removes the whole lines from startY to endY inclusive, with
+// their newlines, as a single remove event. The active cursor is left at the
+// start of the line that followed them, or of the new last line if the last
+// line of the buffer was removed
+func (eh *EventHandler) RemoveLines(startY, endY int) {
+	eh.mu.Lock()
+	defer eh.mu.Unlock()
+	la := eh.buf.LineArray
+	if endY < startY {
+		startY, endY = endY, startY
+	}
+	last := la.LinesNum() - 1
+	startY, endY = util.Clamp(startY, 0, last), util.Clamp(endY, 0, last)
+
+	start, end := Loc{0, startY}, Loc{0, endY + 1}
+	if endY == last {
+		end = Loc{util.CharacterCount(la.LineBytes(endY)), endY}
+		if startY > 0 {
+			// remove the newline before the lines instead of the missing one after them
+			start = Loc{util.CharacterCount(la.LineBytes(startY - 1)), startY - 1}
+		}
+	}
+	eh.removeText(start, end)
+
+	c := eh.activeCursor()
+	c.ResetSelection()
+	c.GotoLoc(Loc{0, util.Clamp(startY, 0, la.LinesNum()-1)})
+}
+
+// Duplicate inserts a copy of the text between start and end right after it
+// as a single insert event, and selects the copy with the active cursor. If
+// start and end are equal, the whole line containing them is duplicated
+// and the cursor moves to the same column on the new line
+func (eh *EventHandler) Duplicate(start, end Loc) {
+	eh.mu.Lock()
+	defer eh.mu.Unlock()
+	la := eh.buf.LineArray
+	if end.LessThan(start) {
+		start, end = end, start
+	}
+	start, end = clamp(start, la), clamp(end, la)
+	c := eh.activeCursor()
+
+	if start == end {
+		line := la.LineBytes(start.Y)
+		text := make([]byte, 0, len(line)+1)
+		if start.Y == la.LinesNum()-1 {
+			text = append(append(text, '\n'), line...)
+			eh.insertBytes(Loc{util.CharacterCount(line), start.Y}, text)
+		} else {
+			text = append(append(text, line...), '\n')
+			eh.insertBytes(Loc{0, start.Y + 1}, text)
+		}
+		c.ResetSelection()
+		c.GotoLoc(Loc{start.X, start.Y + 1})
+		return
+	}
+
+	text := la.Substr(start, end)
+	size := DiffLA(start, end, la)
+	if start.X == 0 && end.X > 0 && end.X == util.CharacterCount(la.LineBytes(end.Y)) {
+		// full lines without their last newline: put the copy on its own lines
+		text = append([]byte{'\n'}, text...)
+		eh.insertBytes(end, text)
+		end = end.MoveLA(1, eh.buf.LineArray)
+	} else {
+		eh.insertBytes(end, text)
+	}
+
+	newEnd := end.MoveLA(size, eh.buf.LineArray)
+	c.SetSelectionStart(end)
+	c.SetSelectionEnd(newEnd)
+	c.OrigSelection = c.CurSelection
+	c.Loc = newEnd
+	c.StoreVisualX()
+}
+
+// IndentLines adds indent to the start of each non-empty line from startY
+// to endY, or removes up to one indent unit from them if dedent is true, as
+// a single replace event. When dedenting, lines indented with less
+// whitespace lose what they have, and a tab always counts as a whole unit.
+// Cursors on the lines move with their text
+func (eh *EventHandler) IndentLines(startY, endY int, indent []byte, dedent bool) {
+	eh.mu.Lock()
+	defer eh.mu.Unlock()
+	la := eh.buf.LineArray
+	if endY < startY {
+		startY, endY = endY, startY
+	}
+	startY = util.Clamp(startY, 0, la.LinesNum()-1)
+	endY = util.Clamp(endY, 0, la.LinesNum()-1)
+	unit := util.CharacterCount(indent)
+
+	shift := make(map[int]int)
+	var deltas []Delta
+	for y := endY; y >= startY; y-- {
+		line := la.LineBytes(y)
+		if !dedent {
+			if len(line) > 0 {
+				deltas = append(deltas, Delta{indent, Loc{0, y}, Loc{0, y}})
+				shift[y] = unit
+			}
+			continue
+		}
+
+		n := 0
+		if !bytes.HasPrefix(line, indent) {
+			for n < len(line) && n < unit && util.IsWhitespace(rune(line[n])) {
+				n++
+				if line[n-1] == '\t' {
+					break
+				}
+			}
+		} else {
+			n = unit
+		}
+		if n > 0 {
+			deltas = append(deltas, Delta{[]byte{}, Loc{0, y}, Loc{n, y}})
+			shift[y] = -n
+		}
+	}
+	if len(deltas) == 0 {
+		return
+	}
+	eh.multipleReplace(deltas)
+
+	move := func(l *Loc) {
+		if d, ok := shift[l.Y]; ok {
+			l.X += d
+			if l.X < 0 {
+				l.X = 0
+			}
+		}
+	}
+	for _, c := range eh.cursors {
+		move(&c.Loc)
+		move(&c.CurSelection[0])
+		move(&c.CurSelection[1])
+		move(&c.OrigSelection[0])
+		move(&c.OrigSelection[1])
+		c.StoreVisualX()
+	}
+}
+
+// StripTrailingWhitespace removes the trailing whitespace of every line as a
+// single replace event. Lines on which a cursor has just added trailing
+// whitespace, see Cursor.NewTrailingWsY, are left alone. Cursors past the new
+// end of a stripped line are moved to it
+func (eh *EventHandler) StripTrailingWhitespace() {
+	eh.mu.Lock()
+	defer eh.mu.Unlock()
+	la := eh.buf.LineArray
+	keep := make(map[int]bool)
+	for _, c := range eh.cursors {
+		if c.NewTrailingWsY != -1 {
+			keep[c.NewTrailingWsY] = true
+		}
+	}
+
+	lineEnd := make(map[int]int)
+	var deltas []Delta
+	for y := la.LinesNum() - 1; y >= 0; y-- {
+		line := la.LineBytes(y)
+		ws := util.GetTrailingWhitespace(line)
+		if len(ws) == 0 || keep[y] {
+			continue
+		}
+		n := util.CharacterCount(line)
+		end := n - util.CharacterCount(ws)
+		deltas = append(deltas, Delta{[]byte{}, Loc{end, y}, Loc{n, y}})
+		lineEnd[y] = end
+	}
+	if len(deltas) == 0 {
+		return
+	}
+	eh.multipleReplace(deltas)
+
+	move := func(l *Loc) {
+		if end, ok := lineEnd[l.Y]; ok && l.X > end {
+			l.X = end
+		}
+	}
+	for _, c := range eh.cursors {
+		move(&c.Loc)
+		move(&c.CurSelection[0])
+		move(&c.CurSelection[1])
+		move(&c.OrigSelection[0])
+		move(&c.OrigSelection[1])
+		c.StoreVisualX()
+	}
+}
+
+// Case transforms for TransformCase
+const (
+	CaseUpper = iota
+	CaseLower
+	CaseToggle
+)
+
+// TransformCase changes the case of the text between start and end to
+// upper case, lower case, or the opposite of each character's case,
+// depending on mode. The change is a single replace event. Characters are
+// mapped one to one, so locations in the range stay valid
+func (eh *EventHandler) TransformCase(start, end Loc, mode int) {
+	eh.mu.Lock()
+	defer eh.mu.Unlock()
+	la := eh.buf.LineArray
+	if end.LessThan(start) {
+		start, end = end, start
+	}
+	start, end = clamp(start, la), clamp(end, la)
+
+	old := string(la.Substr(start, end))
+	text := strings.Map(func(r rune) rune {
+		switch mode {
+		case CaseUpper:
+			return unicode.ToUpper(r)
+		case CaseLower:
+			return unicode.ToLower(r)
+		}
+		if unicode.IsUpper(r) {
+			return unicode.ToLower(r)
+		}
+		return unicode.ToUpper(r)
+	}, old)
+	if text == old {
+		return
+	}
+	eh.multipleReplace([]Delta{{[]byte(text), start, end}})
+}
+
+// SortLines sorts the lines from startY to endY as a single replace event.
+// With caseInsensitive the lines are compared ignoring case, and with
+// numeric lines starting with an integer are ordered by its value before
+// the lines that don't, all other ties being compared as text. The empty
+// line after the final newline of the buffer is never moved. Cursors keep
+// their line and column, clamped to the new length of their line
+func (eh *EventHandler) SortLines(startY, endY int, reverse, caseInsensitive, numeric bool) {
+	eh.mu.Lock()
+	defer eh.mu.Unlock()
+	la := eh.buf.LineArray
+	if endY < startY {
+		startY, endY = endY, startY
+	}
+	startY = util.Clamp(startY, 0, la.LinesNum()-1)
+	endY = util.Clamp(endY, 0, la.LinesNum()-1)
+	if endY > startY && endY == la.LinesNum()-1 && len(la.LineBytes(endY)) == 0 {
+		endY--
+	}
+
+	lines := make([]string, 0, endY-startY+1)
+	for y := startY; y <= endY; y++ {
+		lines = append(lines, string(la.LineBytes(y)))
+	}
+	key := func(s string) string {
+		if caseInsensitive {
+			return strings.ToLower(s)
+		}
+		return s
+	}
+	less := func(a, b string) bool {
+		if numeric {
+			na, oka := leadingInt(a)
+			nb, okb := leadingInt(b)
+			if oka != okb {
+				return oka
+			}
+			if oka && na != nb {
+				return na < nb
+			}
+		}
+		return key(a) < key(b)
+	}
+	sorted := append([]string(nil), lines...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if reverse {
+			return less(sorted[j], sorted[i])
+		}
+		return less(sorted[i], sorted[j])
+	})
+
+	old := strings.Join(lines, "\n")
+	text := strings.Join(sorted, "\n")
+	if text == old {
+		return
+	}
+	end := Loc{util.CharacterCount(la.LineBytes(endY)), endY}
+	eh.multipleReplace([]Delta{{[]byte(text), Loc{0, startY}, end}})
+
+	for _, c := range eh.cursors {
+		c.Relocate()
+		c.CurSelection[0] = eh.validLoc(c.CurSelection[0])
+		c.CurSelection[1] = eh.validLoc(c.CurSelection[1])
+		c.StoreVisualX()
+	}
+}
+
+// JoinLines joins the lines from startY to endY into one as a single replace
+// event, or line startY with the next one if both are the same. Like vim's J
+// command the whitespace around each join is collapsed to a single space, or
+// removed if either side is empty. The active cursor is moved to the last
+// join point and the other cursors are clamped to the buffer
+func (eh *EventHandler) JoinLines(startY, endY int) {
+	eh.mu.Lock()
+	defer eh.mu.Unlock()
+	la := eh.buf.LineArray
+	if endY < startY {
+		startY, endY = endY, startY
+	}
+	if startY == endY {
+		endY++
+	}
+	startY = util.Clamp(startY, 0, la.LinesNum()-1)
+	endY = util.Clamp(endY, 0, la.LinesNum()-1)
+	if startY == endY {
+		return
+	}
+
+	joined := strings.TrimRightFunc(string(la.LineBytes(startY)), unicode.IsSpace)
+	startX := util.CharacterCountInString(joined)
+	joinX := startX
+	for y := startY + 1; y <= endY; y++ {
+		joined = strings.TrimRightFunc(joined, unicode.IsSpace)
+		next := strings.TrimLeftFunc(string(la.LineBytes(y)), unicode.IsSpace)
+		joinX = util.CharacterCountInString(joined)
+		if joined != "" && next != "" {
+			joined += " "
+		}
+		joined += next
+	}
+
+	start := Loc{startX, startY}
+	end := Loc{util.CharacterCount(la.LineBytes(endY)), endY}
+	text := string([]rune(joined)[startX:])
+	eh.multipleReplace([]Delta{{[]byte(text), start, end}})
+
+	for _, c := range eh.cursors {
+		c.Relocate()
+		c.CurSelection[0] = eh.validLoc(c.CurSelection[0])
+		c.CurSelection[1] = eh.validLoc(c.CurSelection[1])
+		c.StoreVisualX()
+	}
+	c := eh.activeCursor()
+	c.ResetSelection()
+	c.GotoLoc(Loc{joinX, startY})
+}
+
+// leadingInt returns the integer at the start of s, after any whitespace
+func leadingInt(s string) (int64, bool) {
+	s = strings.TrimLeftFunc(s, unicode.IsSpace)
+	n := 0
+	if n < len(s) && (s[n] == '-' || s[n] == '+') {
+		n++
+	}
+	digits := n
+	for n < len(s) && s[n] >= '0' && s[n] <= '9' {
+		n++
+	}
+	if n == digits {
+		return 0, false
+	}
+	i, err := strconv.ParseInt(s[:n], 10, 64)
+	return i, err == nil
+}
+
+// An Edit is a replacement of the text between Start and End
+type Edit struct {
+	Start Loc
+	End   Loc
+	Text  string
+}
+
+// ApplyEdits applies a list of independent edits as a single undoable
+// replace event. The edits may be given in any order, but their ranges must
+// not overlap: if any two edits conflict, an error is returned and the
+// buffer is left unchanged
+func (eh *EventHandler) ApplyEdits(edits []Edit) error {
+	eh.mu.Lock()
+	defer eh.mu.Unlock()
+	if len(edits) == 0 {
+		return nil
+	}
+
+	sorted := make([]Edit, len(edits))
+	for i, e := range edits {
+		if e.End.LessThan(e.Start) {
+			e.Start, e.End = e.End, e.Start
+		}
+		e.Start = clamp(e.Start, eh.buf.LineArray)
+		e.End = clamp(e.End, eh.buf.LineArray)
+		sorted[i] = e
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Start.LessThan(sorted[j].Start)
+	})
+
+	for i := 1; i < len(sorted); i++ {
+		prev, cur := sorted[i-1], sorted[i]
+		if cur.Start.LessThan(prev.End) || cur.Start == prev.Start {
+			return fmt.Errorf("Conflicting edits at %d:%d and %d:%d",
+				prev.Start.Y+1, prev.Start.X+1, cur.Start.Y+1, cur.Start.X+1)
+		}
+	}
+
+	// apply the edits from the bottom up so that they don't shift each other
+	deltas := make([]Delta, 0, len(sorted))
+	for i := len(sorted) - 1; i >= 0; i-- {
+		e := sorted[i]
+		deltas = append(deltas, Delta{[]byte(e.Text), e.Start, e.End})
+	}
+	eh.multipleReplace(deltas)
+
+	for _, c := range eh.cursors {
+		c.Relocate()
+	}
+	return nil
+}
+
+// Replace deletes from start to end and replaces it with the given string.
+// The removal and the insertion are undone and redone together
 func (eh *EventHandler) Replace(start, end Loc, replace string) {
-	eh.Remove(start, end)
-	eh.Insert(start, replace)
+	eh.mu.Lock()
+	defer eh.mu.Unlock()
+	eh.beginUndoGroup()
+	defer eh.endUndoGroup()
+	eh.removeText(start, end)
+	eh.insertBytes(start, []byte(replace))
 }
 
 // Execute a textevent and add it to the undo stack
 func (eh *EventHandler) Execute(t *TextEvent) {
+	eh.mu.Lock()
+	defer eh.mu.Unlock()
+	eh.execute(t)
+}
+
+// execute is Execute without locking the event handler
+func (eh *EventHandler) execute(t *TextEvent) {
+	if eh.recording {
+		eh.record(t)
+	}
+	if prev := eh.coalesceTarget(t); prev != nil {
+		ExecuteTextEvent(t, eh.buf)
+		d := &prev.Deltas[0]
+		d.Text = append(d.Text[:len(d.Text):len(d.Text)], t.Deltas[0].Text...)
+		d.End = textEnd(d.Start, d.Text)
+		if eh.RecordSnapshots {
+			prev.after = eh.buf.Bytes()
+		}
+		eh.trimUndoHistory()
+		return
+	}
+
+	t.Group = eh.group
+	eh.treeExecute(t)
 	if eh.RedoStack.Len() > 0 {
 		eh.RedoStack = new(TEStack)
 	}
 	eh.UndoStack.Push(t)
 
+	if eh.RecordSnapshots {
+		t.before = eh.buf.Bytes()
+	}
 	ExecuteTextEvent(t, eh.buf)
+	if eh.RecordSnapshots {
+		t.after = eh.buf.Bytes()
+	}
+
+	eh.trimUndoHistory()
+}
+
+// TrimUndoHistory drops the oldest events from the undo stack until it fits
+// within MaxUndoEvents and MaxUndoBytes. The most recent event is always kept
+func (eh *EventHandler) TrimUndoHistory() {
+	eh.mu.Lock()
+	defer eh.mu.Unlock()
+	eh.trimUndoHistory()
+}
+
+// trimUndoHistory is TrimUndoHistory without locking the event handler
+func (eh *EventHandler) trimUndoHistory() {
+	keep := eh.UndoStack.Len()
+	if eh.MaxUndoEvents > 0 && keep > eh.MaxUndoEvents {
+		keep = eh.MaxUndoEvents
+	}
+
+	if eh.MaxUndoBytes > 0 {
+		size := 0
+		n := 0
+		for e := eh.UndoStack.Top; e != nil && n < keep; e = e.Next {
+			for _, d := range e.Value.Deltas {
+				size += len(d.Text)
+			}
+			if size > eh.MaxUndoBytes && n > 0 {
+				break
+			}
+			n++
+		}
+		keep = n
+	}
+
+	if keep < eh.UndoStack.Len() {
+		eh.treeTrim(keep)
+		eh.UndoStack.Truncate(keep)
+	}
+}
+
+// BeginUndoGroup starts a group of events that are undone and redone as a
+// single unit, regardless of how far apart in time they were executed.
+// Groups may be nested, in which case the outermost group is used
+func (eh *EventHandler) BeginUndoGroup() {
+	eh.mu.Lock()
+	defer eh.mu.Unlock()
+	eh.beginUndoGroup()
+}
+
+// beginUndoGroup is BeginUndoGroup without locking the event handler
+func (eh *EventHandler) beginUndoGroup() {
+	if eh.groupDepth == 0 {
+		eh.group++
+		if t := eh.UndoStack.Peek(); t != nil && t.Group >= eh.group {
+			eh.group = t.Group + 1
+		}
+	}
+	eh.groupDepth++
+}
+
+// EndUndoGroup ends the group started by the matching BeginUndoGroup
+func (eh *EventHandler) EndUndoGroup() {
+	eh.mu.Lock()
+	defer eh.mu.Unlock()
+	eh.endUndoGroup()
+}
+
+// endUndoGroup is EndUndoGroup without locking the event handler
+func (eh *EventHandler) endUndoGroup() {
+	if eh.groupDepth == 0 {
+		return
+	}
+	eh.groupDepth--
+	if eh.groupDepth == 0 {
+		eh.group = 0
+	}
+}
+
+// UndoStackSize returns the number of events that can be undone
+func (eh *EventHandler) UndoStackSize() int {
+	eh.mu.RLock()
+	defer eh.mu.RUnlock()
+	return eh.UndoStack.Len()
+}
+
+// RedoStackSize returns the number of events that can be redone
+func (eh *EventHandler) RedoStackSize() int {
+	eh.mu.RLock()
+	defer eh.mu.RUnlock()
+	return eh.RedoStack.Len()
+}
+
+// CanUndo returns true if there is an event to undo
+func (eh *EventHandler) CanUndo() bool {
+	eh.mu.RLock()
+	defer eh.mu.RUnlock()
+	return eh.UndoStack.Len() > 0
+}
+
+// CanRedo returns true if there is an event to redo
+func (eh *EventHandler) CanRedo() bool {
+	eh.mu.RLock()
+	defer eh.mu.RUnlock()
+	return eh.RedoStack.Len() > 0
+}
+
+// DropRedo removes the top n events from the redo stack without applying
+// them. The events below them stay on the stack and may be redone afterwards
+func (eh *EventHandler) DropRedo(n int) {
+	eh.mu.Lock()
+	defer eh.mu.Unlock()
+	first := eh.RedoStack.Peek()
+	if first == nil || n <= 0 {
+		return
+	}
+	eh.curNode()
+	for i := 0; i < n; i++ {
+		if eh.RedoStack.Pop() == nil {
+			break
+		}
+	}
+	eh.treeDrop(first, eh.RedoStack.Peek())
+}
+
+// coalesceTarget returns the event on top of the undo stack if t should be
+// merged into it instead of being pushed: t must insert a single character
+// right where the previous insert by the same cursor ended, within the undo
+// threshold of it. Newlines are never merged, so typing a line of text makes
+// a single event
+func (eh *EventHandler) coalesceTarget(t *TextEvent) *TextEvent {
+	if t.EventType != TextEventInsert || len(t.Deltas) != 1 || eh.RedoStack.Len() > 0 {
+		return nil
+	}
+	text := t.Deltas[0].Text
+	if util.CharacterCount(text) != 1 || text[0] == '\n' {
+		return nil
+	}
+
+	prev := eh.UndoStack.Peek()
+	if prev == nil || prev.EventType != TextEventInsert || len(prev.Deltas) != 1 ||
+		prev.Group != eh.group || prev.C.Num != t.C.Num || prev.Deltas[0].End != t.Deltas[0].Start ||
+		bytes.IndexByte(prev.Deltas[0].Text, '\n') >= 0 {
+		return nil
+	}
+	// the previous event must be the current node of the undo tree, without
+	// branches that depend on its text
+	if eh.tree != nil && (eh.tree.event != prev || len(eh.tree.children) > 0) {
+		return nil
+	}
+
+	threshold := eh.undoThreshold()
+	if threshold <= 0 || t.Time.Sub(prev.Time) >= time.Duration(threshold)*time.Millisecond {
+		return nil
+	}
+	return prev
+}
+
+// undoThreshold returns the window in milliseconds within which events are
+// undone and redone together, read from the buffer's undothreshold setting
+func (eh *EventHandler) undoThreshold() int64 {
+	if v, ok := eh.buf.Settings["undothreshold"].(float64); ok {
+		return int64(v)
+	}
+	return defaultUndoThreshold
 }
 
 // Undo the first event in the undo stack. Returns false if the stack is empty.
 func (eh *EventHandler) Undo() bool {
+	eh.mu.Lock()
+	defer eh.mu.Unlock()
+	return eh.undo()
+}
+
+// undo is Undo without locking the event handler
+func (eh *EventHandler) undo() bool {
 	t := eh.UndoStack.Peek()
 	if t == nil {
 		return false
 	}
 
+	if t.Group != 0 {
+		for g := t.Group; t != nil && t.Group == g; t = eh.UndoStack.Peek() {
+			eh.undoOneEvent()
+		}
+		return true
+	}
+
+	threshold := eh.undoThreshold()
+	if threshold <= 0 {
+		eh.undoOneEvent()
+		return true
+	}
+
 	startTime := t.Time.UnixNano() / int64(time.Millisecond)
-	endTime := startTime - (startTime % undoThreshold)
+	endTime := startTime - (startTime % threshold)
 
 	for {
 		t = eh.UndoStack.Peek()
-		if t == nil {
+		if t == nil || t.Group != 0 {
 			break
 		}
 
@@ -260,46 +1427,122 @@ func (eh *EventHandler) Undo() bool {
 			break
 		}
 
-		eh.UndoOneEvent()
+		eh.undoOneEvent()
 	}
 	return true
 }
 
+// UndoWithDeltas undoes like Undo and also returns the changes the undo made
+// to the buffer, across all the events it undid. Each delta replaces the text
+// from Start to End with Text, and the deltas must be applied in order: an
+// undone insert gives a delta with no Text and an undone remove gives one
+// with Start equal to End
+func (eh *EventHandler) UndoWithDeltas() (bool, []Delta) {
+	eh.mu.Lock()
+	defer eh.mu.Unlock()
+	eh.collecting = true
+	ok := eh.undo()
+	deltas := eh.collected
+	eh.collecting = false
+	eh.collected = nil
+	return ok, deltas
+}
+
 // UndoOneEvent undoes one event
 func (eh *EventHandler) UndoOneEvent() {
+	eh.mu.Lock()
+	defer eh.mu.Unlock()
+	eh.undoOneEvent()
+}
+
+// undoOneEvent is UndoOneEvent without locking the event handler
+func (eh *EventHandler) undoOneEvent() {
 	// This event should be undone
 	// Pop it off the stack
+	eh.curNode()
 	t := eh.UndoStack.Pop()
 	if t == nil {
 		return
 	}
+	eh.treeUndo()
 	// Undo it
 	// Modifies the text event
-	eh.UndoTextEvent(t)
+	eh.undoTextEvent(t)
 
 	// Set the cursor in the right place
 	if t.C.Num >= 0 && t.C.Num < len(eh.cursors) {
-		eh.cursors[t.C.Num].Goto(t.C)
-		eh.cursors[t.C.Num].NewTrailingWsY = t.C.NewTrailingWsY
+		c := eh.cursors[t.C.Num]
+		c.Goto(t.C)
+		c.NewTrailingWsY = t.C.NewTrailingWsY
+
+		if eh.buf.Settings["selectonundo"].(bool) {
+			// Select the text that the undo put back into the buffer
+			start, end := undoneSpan(t)
+			c.SetSelectionStart(start)
+			c.SetSelectionEnd(end)
+			c.OrigSelection = c.CurSelection
+			c.Loc = end
+			c.StoreVisualX()
+		}
 	}
 
 	// Push it to the redo stack
 	eh.RedoStack.Push(t)
 }
 
+// undoneSpan returns the combined range of all the deltas of an event that
+// has just been undone. If the undo removed text (i.e. it reverted an insert)
+// the range is empty and located where the text used to be
+func undoneSpan(t *TextEvent) (Loc, Loc) {
+	var start, end Loc
+	for i, d := range t.Deltas {
+		dstart, dend := d.Start, d.End
+		if t.EventType == TextEventRemove {
+			dend = dstart
+		}
+		if i == 0 || dstart.LessThan(start) {
+			start = dstart
+		}
+		if i == 0 || dend.GreaterThan(end) {
+			end = dend
+		}
+	}
+	return start, end
+}
+
 // Redo the first event in the redo stack. Returns false if the stack is empty.
 func (eh *EventHandler) Redo() bool {
+	eh.mu.Lock()
+	defer eh.mu.Unlock()
+	return eh.redo()
+}
+
+// redo is Redo without locking the event handler
+func (eh *EventHandler) redo() bool {
 	t := eh.RedoStack.Peek()
 	if t == nil {
 		return false
 	}
 
+	if t.Group != 0 {
+		for g := t.Group; t != nil && t.Group == g; t = eh.RedoStack.Peek() {
+			eh.redoOneEvent()
+		}
+		return true
+	}
+
+	threshold := eh.undoThreshold()
+	if threshold <= 0 {
+		eh.redoOneEvent()
+		return true
+	}
+
 	startTime := t.Time.UnixNano() / int64(time.Millisecond)
-	endTime := startTime - (startTime % undoThreshold) + undoThreshold
+	endTime := startTime - (startTime % threshold) + threshold
 
 	for {
 		t = eh.RedoStack.Peek()
-		if t == nil {
+		if t == nil || t.Group != 0 {
 			break
 		}
 
@@ -307,17 +1550,26 @@ func (eh *EventHandler) Redo() bool {
 			break
 		}
 
-		eh.RedoOneEvent()
+		eh.redoOneEvent()
 	}
 	return true
 }
 
 // RedoOneEvent redoes one event
 func (eh *EventHandler) RedoOneEvent() {
+	eh.mu.Lock()
+	defer eh.mu.Unlock()
+	eh.redoOneEvent()
+}
+
+// redoOneEvent is RedoOneEvent without locking the event handler
+func (eh *EventHandler) redoOneEvent() {
+	eh.curNode()
 	t := eh.RedoStack.Pop()
 	if t == nil {
 		return
 	}
+	eh.treeRedo(t)
 
 	if t.C.Num >= 0 && t.C.Num < len(eh.cursors) {
 		eh.cursors[t.C.Num].Goto(t.C)
@@ -325,7 +1577,7 @@ func (eh *EventHandler) RedoOneEvent() {
 	}
 
 	// Modifies the text event
-	eh.UndoTextEvent(t)
+	eh.undoTextEvent(t)
 
 	eh.UndoStack.Push(t)
 }
@@ -339,7 +1591,7 @@ func (eh *EventHandler) updateTrailingWs(t *TextEvent) {
 	start := t.Deltas[0].Start
 	end := t.Deltas[0].End
 
-	c := eh.cursors[eh.active]
+	c := eh.activeCursor()
 	isEol := func(loc Loc) bool {
 		return loc.X == util.CharacterCount(eh.buf.LineBytes(loc.Y))
 	}
@@ -0,0 +1,105 @@
+package buffer
+
+import (
+	"time"
+)
+
+// StartRecording starts recording a macro. Every event executed from now on
+// until StopRecording is recorded relative to the current position of the
+// active cursor. Starting a new recording discards the events recorded so far
+func (eh *EventHandler) StartRecording() {
+	eh.mu.Lock()
+	defer eh.mu.Unlock()
+	eh.recording = true
+	eh.recorded = nil
+	eh.recordOrigin = eh.activeCursor().Loc
+}
+
+// StopRecording stops recording and returns the recorded events. Their
+// locations are relative to the cursor position where the recording started
+// and the events only use exported fields, so they can be stored with gob
+// and replayed in another session
+func (eh *EventHandler) StopRecording() []TextEvent {
+	eh.mu.Lock()
+	defer eh.mu.Unlock()
+	events := eh.recorded
+	eh.recording = false
+	eh.recorded = nil
+	return events
+}
+
+// Recording returns true if a macro is being recorded
+func (eh *EventHandler) Recording() bool {
+	eh.mu.RLock()
+	defer eh.mu.RUnlock()
+	return eh.recording
+}
+
+// record adds a copy of an event that is about to be executed to the
+// recorded macro
+func (eh *EventHandler) record(t *TextEvent) {
+	deltas := make([]Delta, len(t.Deltas))
+	for i, d := range t.Deltas {
+		deltas[i] = Delta{
+			Text:  append([]byte(nil), d.Text...),
+			Start: relativeLoc(d.Start, eh.recordOrigin),
+			End:   relativeLoc(d.End, eh.recordOrigin),
+		}
+	}
+	eh.recorded = append(eh.recorded, TextEvent{
+		EventType: t.EventType,
+		Deltas:    deltas,
+	})
+}
+
+// Replay executes events returned by StopRecording at the position of the
+// active cursor. The events are added to the undo stack as a single group,
+// so the whole macro is undone at once. Locations that fall outside the
+// buffer are moved to the closest valid location
+func (eh *EventHandler) Replay(events []TextEvent) {
+	if len(events) == 0 {
+		return
+	}
+	eh.mu.Lock()
+	defer eh.mu.Unlock()
+	eh.beginUndoGroup()
+	defer eh.endUndoGroup()
+
+	origin := eh.activeCursor().Loc
+	for _, r := range events {
+		deltas := make([]Delta, len(r.Deltas))
+		for i, d := range r.Deltas {
+			deltas[i] = Delta{
+				Text:  append([]byte(nil), d.Text...),
+				Start: eh.validLoc(absoluteLoc(d.Start, origin)),
+				End:   eh.validLoc(absoluteLoc(d.End, origin)),
+			}
+		}
+		e := &TextEvent{
+			C:         *eh.activeCursor(),
+			EventType: r.EventType,
+			Deltas:    deltas,
+			Time:      time.Now(),
+		}
+		eh.doTextEvent(e, true)
+	}
+}
+
+// relativeLoc returns l relative to origin. Only locations on the line of
+// origin are shifted horizontally, other lines keep their columns
+func relativeLoc(l, origin Loc) Loc {
+	if l.Y == origin.Y {
+		l.X -= origin.X
+	}
+	l.Y -= origin.Y
+	return l
+}
+
+// absoluteLoc is the inverse of relativeLoc
+func absoluteLoc(l, origin Loc) Loc {
+	if l.Y == 0 {
+		l.X += origin.X
+	}
+	l.Y += origin.Y
+	return l
+}
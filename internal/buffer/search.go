@@ -2,6 +2,7 @@ package buffer
 
 import (
 	"regexp"
+	"sort"
 	"unicode/utf8"
 
 	"github.com/zyedidia/micro/v2/internal/util"
@@ -138,6 +139,62 @@ func (b *Buffer) findAll(r *regexp.Regexp, start, end Loc) [][2]Loc {
 	return matches
 }
 
+// searchCache holds all the matches of a search in the buffer
+type searchCache struct {
+	search     string
+	useRegex   bool
+	ignorecase bool
+	edits      int
+	matches    [][2]Loc
+}
+
+// searchRegexp compiles a search string the way FindNext does
+func (b *Buffer) searchRegexp(s string, useRegex bool) (*regexp.Regexp, error) {
+	if !useRegex {
+		s = regexp.QuoteMeta(s)
+	}
+	if b.Settings["ignorecase"].(bool) {
+		return regexp.Compile("(?i)" + s)
+	}
+	return regexp.Compile(s)
+}
+
+// SearchMatches returns the number of matches of the last search in the
+// buffer and the 1-based index of the match at the active cursor, or of the
+// last match before it. The index is 0 if the cursor is before all matches
+func (b *Buffer) SearchMatches() (int, int) {
+	if b.LastSearch == "" {
+		return 0, 0
+	}
+
+	c := &b.searchCache
+	ignorecase := b.Settings["ignorecase"].(bool)
+	if c.matches == nil || c.search != b.LastSearch || c.useRegex != b.LastSearchRegex ||
+		c.ignorecase != ignorecase || c.edits != b.edits {
+		r, err := b.searchRegexp(b.LastSearch, b.LastSearchRegex)
+		if err != nil {
+			return 0, 0
+		}
+		*c = searchCache{b.LastSearch, b.LastSearchRegex, ignorecase, b.edits, b.findAll(r, b.Start(), b.End())}
+		if c.matches == nil {
+			c.matches = [][2]Loc{}
+		}
+	}
+
+	cur := b.GetActiveCursor()
+	pos := cur.Loc
+	if cur.HasSelection() {
+		pos = cur.CurSelection[0]
+		if cur.CurSelection[1].LessThan(pos) {
+			pos = cur.CurSelection[1]
+		}
+	}
+	index := sort.Search(len(c.matches), func(i int) bool {
+		return c.matches[i][0].GreaterThan(pos)
+	})
+	return len(c.matches), index
+}
+
 // FindNext finds the next occurrence of a given string in the buffer
 // It returns the start and end location of the match (if found) and
 // a boolean indicating if it was found
@@ -147,19 +204,7 @@ func (b *Buffer) FindNext(s string, start, end, from Loc, down bool, useRegex bo
 		return [2]Loc{}, false, nil
 	}
 
-	var r *regexp.Regexp
-	var err error
-
-	if !useRegex {
-		s = regexp.QuoteMeta(s)
-	}
-
-	if b.Settings["ignorecase"].(bool) {
-		r, err = regexp.Compile("(?i)" + s)
-	} else {
-		r, err = regexp.Compile(s)
-	}
-
+	r, err := b.searchRegexp(s, useRegex)
 	if err != nil {
 		return [2]Loc{}, false, err
 	}
@@ -189,6 +234,26 @@ func (b *Buffer) ReplaceRegex(start, end Loc, search *regexp.Regexp, replace []b
 	}
 
 	charsEnd := util.CharacterCount(b.LineBytes(end.Y))
+	found, deltas := b.regexReplaceDeltas(start, end, search, replace, captureGroups)
+
+	b.MultipleReplace(deltas)
+
+	return found, util.CharacterCount(b.LineBytes(end.Y)) - charsEnd
+}
+
+// CountRegex returns the number of replacements that ReplaceRegex would make
+// in the given area, without changing the buffer
+func (b *Buffer) CountRegex(start, end Loc, search *regexp.Regexp) int {
+	if start.GreaterThan(end) {
+		start, end = end, start
+	}
+	found, _ := b.regexReplaceDeltas(start, end, search, nil, false)
+	return found
+}
+
+// regexReplaceDeltas returns the number of matches of search between start
+// and end, which must be ordered, and the deltas that replace them
+func (b *Buffer) regexReplaceDeltas(start, end Loc, search *regexp.Regexp, replace []byte, captureGroups bool) (int, []Delta) {
 	found := 0
 	var deltas []Delta
 
@@ -231,8 +296,5 @@ func (b *Buffer) ReplaceRegex(start, end Loc, search *regexp.Regexp, replace []b
 			deltas = append(deltas, Delta{newLine, Loc{0, i}, Loc{charCount, i}})
 		}
 	}
-
-	b.MultipleReplace(deltas)
-
-	return found, util.CharacterCount(b.LineBytes(end.Y)) - charsEnd
+	return found, deltas
 }
@@ -2,8 +2,10 @@ package buffer
 
 import (
 	"bytes"
+	"crypto/md5"
 	"encoding/gob"
 	"errors"
+	"io"
 	"os"
 	"path/filepath"
 	"time"
@@ -15,9 +17,71 @@ import (
 // The SerializedBuffer holds the types that get serialized when a buffer is saved
 // These are used for the savecursor and saveundo options
 type SerializedBuffer struct {
-	EventHandler *EventHandler
-	Cursor       Loc
-	ModTime      time.Time
+	Cursor  Loc
+	ModTime time.Time
+	// History is the undo and redo history, nil unless saveundo is on
+	History *SerializedHistory
+}
+
+// ErrHistoryMismatch is returned by LoadHistory when the saved history was
+// recorded against different buffer contents
+var ErrHistoryMismatch = errors.New("undo history does not match the buffer contents")
+
+// SerializedHistory is the on-disk format of an undo and redo history. The
+// hash of the buffer contents guards against applying it to a different text
+type SerializedHistory struct {
+	Hash [md5.Size]byte
+	Undo []*TextEvent
+	Redo []*TextEvent
+}
+
+// history returns the undo and redo stacks along with a hash of the current
+// buffer contents
+func (eh *EventHandler) history() *SerializedHistory {
+	eh.mu.RLock()
+	defer eh.mu.RUnlock()
+	return &SerializedHistory{
+		Hash: md5.Sum(eh.buf.Bytes()),
+		Undo: eh.UndoStack.Slice(),
+		Redo: eh.RedoStack.Slice(),
+	}
+}
+
+// installHistory replaces the undo and redo stacks with h. If the buffer
+// contents differ from when h was recorded the stacks are left untouched and
+// ErrHistoryMismatch is returned
+func (eh *EventHandler) installHistory(h *SerializedHistory) error {
+	eh.mu.Lock()
+	defer eh.mu.Unlock()
+	if h.Hash != md5.Sum(eh.buf.Bytes()) {
+		return ErrHistoryMismatch
+	}
+
+	eh.clearHistory()
+	for _, t := range h.Undo {
+		eh.UndoStack.Push(t)
+	}
+	for _, t := range h.Redo {
+		eh.RedoStack.Push(t)
+	}
+	return nil
+}
+
+// SaveHistory writes the undo and redo stacks to w along with a hash of the
+// current buffer contents
+func (eh *EventHandler) SaveHistory(w io.Writer) error {
+	return gob.NewEncoder(w).Encode(eh.history())
+}
+
+// LoadHistory replaces the undo and redo stacks with a history written by
+// SaveHistory. If the buffer contents differ from when the history was saved
+// the stacks are left untouched and ErrHistoryMismatch is returned
+func (eh *EventHandler) LoadHistory(r io.Reader) error {
+	var h SerializedHistory
+	if err := gob.NewDecoder(r).Decode(&h); err != nil {
+		return err
+	}
+	return eh.installHistory(&h)
 }
 
 // Serialize serializes the buffer to config.ConfigDir/buffers
@@ -29,13 +93,16 @@ func (b *Buffer) Serialize() error {
 		return nil
 	}
 
+	sb := SerializedBuffer{
+		Cursor:  b.GetActiveCursor().Loc,
+		ModTime: b.ModTime,
+	}
+	if b.Settings["saveundo"].(bool) {
+		sb.History = b.history()
+	}
+
 	var buf bytes.Buffer
-	err := gob.NewEncoder(&buf).Encode(SerializedBuffer{
-		b.EventHandler,
-		b.GetActiveCursor().Loc,
-		b.ModTime,
-	})
-	if err != nil {
+	if err := gob.NewEncoder(&buf).Encode(sb); err != nil {
 		return err
 	}
 
@@ -63,13 +130,11 @@ func (b *Buffer) Unserialize() error {
 			b.StartCursor = buffer.Cursor
 		}
 
-		if b.Settings["saveundo"].(bool) {
-			// We should only use last time's eventhandler if the file wasn't modified by someone else in the meantime
-			if b.ModTime == buffer.ModTime {
-				b.EventHandler = buffer.EventHandler
-				b.EventHandler.cursors = b.cursors
-				b.EventHandler.buf = b.SharedBuffer
-			}
+		// We should only use last time's history if the file wasn't modified
+		// by someone else in the meantime. The hash also catches changes that
+		// kept the same modification time
+		if b.Settings["saveundo"].(bool) && buffer.History != nil && b.ModTime == buffer.ModTime {
+			b.installHistory(buffer.History)
 		}
 	}
 	return nil
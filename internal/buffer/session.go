@@ -0,0 +1,88 @@
+package buffer
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/zyedidia/micro/v2/internal/config"
+	"github.com/zyedidia/micro/v2/internal/util"
+)
+
+// A SessionBuffer is an open file stored in the session
+type SessionBuffer struct {
+	Path   string
+	Cursor Loc
+}
+
+// A Session holds the files that were open when micro last exited
+// It is used for the restoresession option
+type Session struct {
+	Buffers []SessionBuffer
+	Active  int
+}
+
+func sessionFile() string {
+	return filepath.Join(config.ConfigDir, "session.json")
+}
+
+// WriteSession saves the given buffers and the index of the active one to
+// config.ConfigDir/session.json. Buffers that are not files are skipped
+func WriteSession(bufs []*Buffer, active *Buffer) error {
+	var s Session
+	for _, b := range bufs {
+		if b.Type != BTDefault || b.Path == "" {
+			continue
+		}
+		if b == active {
+			s.Active = len(s.Buffers)
+		}
+		s.Buffers = append(s.Buffers, SessionBuffer{b.AbsPath, b.GetActiveCursor().Loc})
+	}
+
+	data, err := json.MarshalIndent(s, "", "    ")
+	if err != nil {
+		return err
+	}
+	return util.SafeWrite(sessionFile(), data, true)
+}
+
+// ReadSession opens the buffers saved by WriteSession, with their cursors
+// where they were. It returns the buffers, the index of the buffer that was
+// active and the paths of the files that no longer exist, which are skipped
+func ReadSession() ([]*Buffer, int, []string, error) {
+	data, err := os.ReadFile(sessionFile())
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, 0, nil, nil
+		}
+		return nil, 0, nil, err
+	}
+
+	var s Session
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, 0, nil, errors.New("Error reading session: " + err.Error())
+	}
+
+	var bufs []*Buffer
+	var missing []string
+	active := 0
+	for i, sb := range s.Buffers {
+		if _, err := os.Stat(sb.Path); err != nil {
+			missing = append(missing, sb.Path)
+			continue
+		}
+		b, err := NewBufferFromFileAtLoc(sb.Path, BTDefault, sb.Cursor)
+		if err != nil {
+			missing = append(missing, sb.Path)
+			continue
+		}
+		if i == s.Active {
+			active = len(bufs)
+		}
+		bufs = append(bufs, b)
+	}
+	return bufs, active, missing, nil
+}
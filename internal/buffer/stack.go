@@ -41,3 +41,48 @@ func (s *TEStack) Peek() *TextEvent {
 	}
 	return nil
 }
+
+// PeekN returns the element n positions below the top of the stack without
+// removing it, so PeekN(0) is the same as Peek. If there is no such element,
+// return nil
+func (s *TEStack) PeekN(n int) *TextEvent {
+	if n < 0 || n >= s.Size {
+		return nil
+	}
+	e := s.Top
+	for ; n > 0; n-- {
+		e = e.Next
+	}
+	return e.Value
+}
+
+// Truncate keeps only the n most recently pushed elements, dropping the
+// older ones from the bottom of the stack
+func (s *TEStack) Truncate(n int) {
+	if n <= 0 {
+		s.Top = nil
+		s.Size = 0
+		return
+	}
+	if n >= s.Size {
+		return
+	}
+	e := s.Top
+	for i := 1; i < n; i++ {
+		e = e.Next
+	}
+	e.Next = nil
+	s.Size = n
+}
+
+// Slice returns the events of the stack from the oldest to the most
+// recently pushed, without modifying the stack
+func (s *TEStack) Slice() []*TextEvent {
+	events := make([]*TextEvent, s.Size)
+	i := s.Size - 1
+	for e := s.Top; e != nil; e = e.Next {
+		events[i] = e.Value
+		i--
+	}
+	return events
+}
@@ -33,3 +33,49 @@ func TestStack(t *testing.T) {
 	p = s.Peek()
 	assert.Nil(t, p)
 }
+
+func TestStackTruncate(t *testing.T) {
+	s := new(TEStack)
+	for i := 0; i < 5; i++ {
+		s.Push(&TextEvent{EventType: i})
+	}
+	s.Truncate(2)
+	assert.Equal(t, 2, s.Len())
+	assert.Equal(t, 4, s.Pop().EventType)
+	assert.Equal(t, 3, s.Pop().EventType)
+	assert.Nil(t, s.Pop())
+}
+
+func TestStackPeekN(t *testing.T) {
+	s := new(TEStack)
+	assert.Nil(t, s.PeekN(0))
+	for i := 0; i < 3; i++ {
+		s.Push(&TextEvent{EventType: i})
+	}
+
+	assert.Equal(t, s.Peek(), s.PeekN(0))
+	assert.Equal(t, 1, s.PeekN(1).EventType)
+	assert.Equal(t, 0, s.PeekN(2).EventType)
+	assert.Nil(t, s.PeekN(3))
+	assert.Nil(t, s.PeekN(-1))
+	assert.Equal(t, 3, s.Len())
+}
+
+func TestStackSlice(t *testing.T) {
+	s := new(TEStack)
+	assert.Empty(t, s.Slice())
+	for i := 0; i < 3; i++ {
+		s.Push(&TextEvent{EventType: i})
+	}
+
+	events := s.Slice()
+	assert.Len(t, events, 3)
+	for i, e := range events {
+		assert.Equal(t, i, e.EventType)
+	}
+
+	events[0] = nil
+	assert.Equal(t, 3, s.Len())
+	assert.Equal(t, 2, s.Peek().EventType)
+	assert.Equal(t, 0, s.Slice()[0].EventType)
+}
@@ -0,0 +1,158 @@
+package buffer
+
+// An undoNode is a node in the undo tree. The path from the root to the
+// current node holds the events of the undo stack, and the children of a
+// node are the alternative edits that were made after undoing to it
+type undoNode struct {
+	event    *TextEvent
+	parent   *undoNode
+	children []*undoNode
+}
+
+// curNode returns the node of the undo tree that matches the current state
+// of the buffer. If the tree has been reset, it is rebuilt from the undo and
+// redo stacks as a single branch
+func (eh *EventHandler) curNode() *undoNode {
+	if eh.tree != nil {
+		return eh.tree
+	}
+
+	n := new(undoNode)
+	for _, t := range eh.UndoStack.Slice() {
+		c := &undoNode{event: t, parent: n}
+		n.children = append(n.children, c)
+		n = c
+	}
+	eh.tree = n
+
+	for e := eh.RedoStack.Top; e != nil; e = e.Next {
+		c := &undoNode{event: e.Value, parent: n}
+		n.children = append(n.children, c)
+		n = c
+	}
+	return eh.tree
+}
+
+// clearHistory discards the undo and redo stacks together with the undo tree
+func (eh *EventHandler) clearHistory() {
+	eh.UndoStack = new(TEStack)
+	eh.RedoStack = new(TEStack)
+	eh.tree = nil
+}
+
+// treeExecute records a newly executed event in the undo tree. Unless the
+// undotree option is enabled, the branch that is being replaced is dropped
+func (eh *EventHandler) treeExecute(t *TextEvent) {
+	cur := eh.curNode()
+	if keep, _ := eh.buf.Settings["undotree"].(bool); !keep {
+		cur.children = nil
+	}
+	n := &undoNode{event: t, parent: cur}
+	cur.children = append(cur.children, n)
+	eh.tree = n
+}
+
+// treeUndo moves the current node of the undo tree to its parent
+func (eh *EventHandler) treeUndo() {
+	if eh.tree != nil {
+		eh.tree = eh.tree.parent
+	}
+}
+
+// treeRedo moves the current node of the undo tree to the child holding t
+func (eh *EventHandler) treeRedo(t *TextEvent) {
+	if eh.tree != nil {
+		eh.tree = eh.tree.childWith(t)
+	}
+}
+
+// treeTrim makes the undo tree forget everything older than the n most
+// recent events on the path to the current node
+func (eh *EventHandler) treeTrim(n int) {
+	r := eh.curNode()
+	for i := 0; i < n && r.parent != nil; i++ {
+		r = r.parent
+	}
+	r.parent = nil
+	r.event = nil
+}
+
+// Branches returns the events that can be redone from the current state, one
+// for each branch of the undo tree, from the oldest to the newest. There is
+// more than one only when the undotree option is enabled
+func (eh *EventHandler) Branches() []*TextEvent {
+	eh.mu.Lock()
+	defer eh.mu.Unlock()
+	cur := eh.curNode()
+	events := make([]*TextEvent, len(cur.children))
+	for i, c := range cur.children {
+		events[i] = c.event
+	}
+	return events
+}
+
+// SwitchBranch makes Redo follow the i-th branch returned by Branches. Within
+// the branch, Redo always follows the newest edits
+func (eh *EventHandler) SwitchBranch(i int) {
+	eh.mu.Lock()
+	defer eh.mu.Unlock()
+	cur := eh.curNode()
+	if i < 0 || i >= len(cur.children) {
+		return
+	}
+
+	var chain []*TextEvent
+	for n := cur.children[i]; n != nil; {
+		chain = append(chain, n.event)
+		if len(n.children) == 0 {
+			break
+		}
+		n = n.children[len(n.children)-1]
+	}
+
+	eh.RedoStack = new(TEStack)
+	for j := len(chain) - 1; j >= 0; j-- {
+		eh.RedoStack.Push(chain[j])
+	}
+}
+
+// childWith returns the child of n holding t, or nil
+func (n *undoNode) childWith(t *TextEvent) *undoNode {
+	for _, c := range n.children {
+		if c.event == t {
+			return c
+		}
+	}
+	return nil
+}
+
+// treeDrop removes the branch starting at first from the children of the
+// current node and puts next, a descendant of first, in its place
+func (eh *EventHandler) treeDrop(first, next *TextEvent) {
+	cur := eh.curNode()
+	f := cur.childWith(first)
+	if f == nil {
+		eh.tree = nil
+		return
+	}
+	for i, c := range cur.children {
+		if c == f {
+			cur.children = append(cur.children[:i:i], cur.children[i+1:]...)
+			break
+		}
+	}
+	if next == nil {
+		return
+	}
+
+	// next is on the newest path below first
+	for n := f; len(n.children) > 0; {
+		n = n.children[len(n.children)-1]
+		if n.event == next {
+			n.parent = cur
+			cur.children = append(cur.children, n)
+			return
+		}
+	}
+	eh.tree = nil
+}
@@ -1,8 +1,15 @@
 package config
 
 import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
 	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/micro-editor/tcell/v2"
 )
@@ -10,15 +17,48 @@ import (
 // DefStyle is Micro's default style
 var DefStyle tcell.Style = tcell.StyleDefault
 
-// Colorscheme is the current colorscheme - hardcoded for micromini
+// Colorscheme is the current colorscheme
 var Colorscheme map[string]tcell.Style
 
+// colorCache memoizes the styles resolved by GetColor for the active
+// colorscheme. It is one of the caches in colorCaches
+var colorCache = make(map[string]tcell.Style)
+
+// colorCaches holds a style cache per colorscheme, keyed like
+// activeColorscheme, so that switching between the global and filetype
+// colorschemes keeps the styles already resolved. A colorscheme's cache is
+// dropped when that colorscheme is reloaded or changed
+var colorCaches = make(map[string]map[string]tcell.Style)
+
+// ClearColorCache drops the styles memoized by GetColor for every
+// colorscheme and the loaded filetype colorschemes. It must be called when
+// the truecolor option changes, since the styles were computed for the old
+// palette. The global colorscheme is parsed with the old palette too and
+// should be reloaded with InitColorscheme
+func ClearColorCache() {
+	colorCaches = make(map[string]map[string]tcell.Style)
+	loadedColorschemes = make(map[string]map[string]tcell.Style)
+	activeColorscheme = ""
+	useColorscheme("", globalColorscheme)
+}
+
 // GetColor takes in a syntax group and returns the colorscheme's style for that group
 func GetColor(color string) tcell.Style {
-	st := DefStyle
 	if color == "" {
+		return DefStyle
+	}
+	if st, ok := colorCache[color]; ok {
 		return st
 	}
+	st := resolveColor(color)
+	colorCache[color] = st
+	return st
+}
+
+// resolveColor looks up the style for a syntax group, falling back to the
+// closest parent group for dotted names such as constant.number.hex
+func resolveColor(color string) tcell.Style {
+	st := DefStyle
 	groups := strings.Split(color, ".")
 	if len(groups) > 1 {
 		curGroup := ""
@@ -40,65 +80,478 @@ func GetColor(color string) tcell.Style {
 	return st
 }
 
-// InitColorscheme initializes the hardcoded default dark colorscheme for micromini
+// builtinColorschemes are the colorschemes hardcoded in micromini. The
+// "default" colorscheme is an alias of "default-dark"
+var builtinColorschemes = map[string]func() map[string]tcell.Style{
+	"default-dark":  defaultDarkColorscheme,
+	"default-light": defaultLightColorscheme,
+	"high-contrast": highContrastColorscheme,
+}
+
+// errUnknownColorscheme is returned by SetColorscheme for names that are
+// neither builtin nor found in the runtime files
+var errUnknownColorscheme = errors.New("Unknown colorscheme")
+
+// InitColorscheme loads the colorscheme named by the colorscheme option. If
+// there is no such colorscheme, or it can't be parsed, the hardcoded default
+// dark colorscheme is used and the error is still returned
 func InitColorscheme() error {
-	Colorscheme = make(map[string]tcell.Style)
-	DefStyle = tcell.StyleDefault.Foreground(tcell.ColorWhite).Background(tcell.ColorBlack)
+	name, _ := GetGlobalOption("colorscheme").(string)
+	if name == "" {
+		name = "default"
+	}
+	err := SetColorscheme(name)
+	var warnings colorschemeWarnings
+	if err != nil && !errors.As(err, &warnings) {
+		SetColorscheme("default")
+	}
+	return err
+}
+
+// colorschemeWarnings is returned by SetColorscheme when a colorscheme was
+// loaded but failed validation
+type colorschemeWarnings []string
+
+func (w colorschemeWarnings) Error() string {
+	return strings.Join(w, "; ")
+}
+
+// SetColorscheme makes the colorscheme with the given name the active one.
+// The name is either that of a builtin colorscheme or of a colorscheme in
+// the runtime files. The screen must be redrawn for the change to be visible.
+// A colorscheme with unknown groups is still used, but the returned error
+// lists the problems found by ValidateColorscheme
+func SetColorscheme(name string) error {
+	scheme, err := loadColorscheme(name)
+	if scheme == nil {
+		return err
+	}
+	globalColorscheme = scheme
+	colorschemeName = name
+	activeColorscheme = ""
+	delete(colorCaches, "")
+	useColorscheme("", scheme)
+	return err
+}
+
+// MergeColorscheme sets the styles of the given groups in the active
+// colorscheme, leaving its other groups as they are. The overrides last until
+// another colorscheme is selected
+func MergeColorscheme(overrides map[string]tcell.Style) {
+	merged := make(map[string]tcell.Style, len(Colorscheme)+len(overrides))
+	for group, style := range Colorscheme {
+		merged[group] = style
+	}
+	for group, style := range overrides {
+		merged[group] = style
+	}
+
+	if activeColorscheme == "" {
+		globalColorscheme = merged
+	} else {
+		loadedColorschemes[activeColorscheme] = merged
+	}
+	delete(colorCaches, activeColorscheme)
+	useColorscheme(activeColorscheme, merged)
+}
+
+// ResetColorscheme makes the hardcoded default colorscheme the active one,
+// dropping the overrides applied with MergeColorscheme
+func ResetColorscheme() {
+	SetColorscheme("default")
+}
+
+// loadColorscheme returns the builtin or runtime colorscheme with the given
+// name. If the colorscheme fails validation it is returned along with a
+// colorschemeWarnings error
+func loadColorscheme(name string) (map[string]tcell.Style, error) {
+	if name == "default" {
+		name = "default-dark"
+	}
+
+	if fn, ok := builtinColorschemes[name]; ok {
+		return fn(), nil
+	}
+
+	f := FindRuntimeFile(RTColorscheme, name)
+	if f == nil {
+		return nil, fmt.Errorf("%w %s", errUnknownColorscheme, name)
+	}
+	data, err := f.Data()
+	if err != nil {
+		return nil, err
+	}
+	scheme, err := ParseColorscheme(name, string(data))
+	if err != nil {
+		return nil, err
+	}
+	if warnings := ValidateColorscheme(scheme); len(warnings) > 0 {
+		return scheme, fmt.Errorf("Colorscheme %s: %w", name, colorschemeWarnings(warnings))
+	}
+	return scheme, nil
+}
+
+// globalColorscheme is the colorscheme selected with SetColorscheme
+var globalColorscheme map[string]tcell.Style
+
+// colorschemeName is the name of globalColorscheme
+var colorschemeName string
+
+// CurrentColorschemeName returns the name of the colorscheme last selected
+// with SetColorscheme or InitColorscheme
+func CurrentColorschemeName() string {
+	return colorschemeName
+}
+
+// filetypeColorschemes maps filetypes to the names of the colorschemes that
+// override the global one for buffers of that filetype
+var filetypeColorschemes = make(map[string]string)
+
+// loadedColorschemes caches the colorschemes loaded for filetypes. Unknown
+// colorschemes are stored as nil
+var loadedColorschemes = make(map[string]map[string]tcell.Style)
+
+// activeColorscheme is the name of the filetype colorscheme currently in
+// Colorscheme, or "" if the global colorscheme is in use
+var activeColorscheme string
+
+// SetFiletypeColorscheme makes buffers of the given filetype use the named
+// colorscheme instead of the global one. An empty name removes the override
+func SetFiletypeColorscheme(filetype, scheme string) {
+	if scheme == "" {
+		delete(filetypeColorschemes, filetype)
+	} else {
+		filetypeColorschemes[filetype] = scheme
+		delete(loadedColorschemes, scheme)
+		delete(colorCaches, scheme)
+	}
+	UseGlobalColorscheme()
+}
+
+// UseFiletypeColorscheme makes the colorscheme for the given filetype the
+// active one until the next call to UseFiletypeColorscheme or
+// UseGlobalColorscheme. Filetypes without an override, or whose colorscheme
+// cannot be loaded, use the global colorscheme
+func UseFiletypeColorscheme(filetype string) {
+	name := filetypeColorschemes[filetype]
+	if name == "" {
+		UseGlobalColorscheme()
+		return
+	}
+	scheme, ok := loadedColorschemes[name]
+	if !ok {
+		scheme, _ = loadColorscheme(name)
+		loadedColorschemes[name] = scheme
+		delete(colorCaches, name)
+	}
+	if scheme == nil {
+		UseGlobalColorscheme()
+		return
+	}
+	if name != activeColorscheme {
+		activeColorscheme = name
+		useColorscheme(name, scheme)
+	}
+}
+
+// UseGlobalColorscheme makes the global colorscheme the active one again
+// after UseFiletypeColorscheme
+func UseGlobalColorscheme() {
+	if activeColorscheme != "" {
+		activeColorscheme = ""
+		useColorscheme("", globalColorscheme)
+	}
+}
+
+// optionalColorschemeGroups are groups that the display uses when a
+// colorscheme defines them but that have no hardcoded default
+var optionalColorschemeGroups = []string{
+	"error-message", "gutter-info", "hlsearch", "match-brace", "message",
+	"selection", "symbol", "tab-error", "trailingws",
+}
+
+// minContrastRatio is the contrast ratio below which ValidateColorscheme
+// warns that a group is hard to read. It is lower than the WCAG minimum so
+// that the usual terminal colors on a black background are accepted
+const minContrastRatio = 2
+
+// ValidateColorscheme returns a warning for each group of scheme that is
+// not a known syntax or interface group, and for each group whose foreground
+// and background have a contrast ratio below minContrastRatio. Subgroups such
+// as constant.string.char are accepted if one of their parent groups is known.
+// Groups using the terminal's default colors and indent-char, which is meant
+// to be faint, are not checked for contrast
+func ValidateColorscheme(scheme map[string]tcell.Style) []string {
+	known := defaultDarkColorscheme()
+	for _, g := range optionalColorschemeGroups {
+		known[g] = DefStyle
+	}
+
+	var warnings []string
+	for group := range scheme {
+		g := group
+		for {
+			if _, ok := known[g]; ok {
+				break
+			}
+			i := strings.LastIndex(g, ".")
+			if i < 0 {
+				warnings = append(warnings, fmt.Sprintf("unknown group %q", group))
+				break
+			}
+			g = g[:i]
+		}
+
+		fg, bg, _ := scheme[group].Decompose()
+		if group == "indent-char" || !fg.Valid() || !bg.Valid() {
+			continue
+		}
+		if ratio := ContrastRatio(fg, bg); ratio < minContrastRatio {
+			warnings = append(warnings, fmt.Sprintf("low contrast in group %q (%.1f:1)", group, ratio))
+		}
+	}
+	sort.Strings(warnings)
+	return warnings
+}
+
+// ContrastRatio returns the WCAG contrast ratio between two colors, from 1
+// for identical colors to 21 for black and white. It returns 0 if either
+// color has no known RGB value, such as the terminal's default color
+func ContrastRatio(fg, bg tcell.Color) float64 {
+	l1, ok1 := relativeLuminance(fg)
+	l2, ok2 := relativeLuminance(bg)
+	if !ok1 || !ok2 {
+		return 0
+	}
+	if l1 < l2 {
+		l1, l2 = l2, l1
+	}
+	return (l1 + 0.05) / (l2 + 0.05)
+}
+
+// relativeLuminance returns the WCAG relative luminance of a color
+func relativeLuminance(c tcell.Color) (float64, bool) {
+	r, g, b := c.RGB()
+	if r < 0 {
+		return 0, false
+	}
+	linear := func(v int32) float64 {
+		s := float64(v) / 255
+		if s <= 0.03928 {
+			return s / 12.92
+		}
+		return math.Pow((s+0.055)/1.055, 2.4)
+	}
+	return 0.2126*linear(r) + 0.7152*linear(g) + 0.0722*linear(b), true
+}
+
+// useColorscheme makes scheme the active colorscheme, along with the style
+// cache kept for it under name
+func useColorscheme(name string, scheme map[string]tcell.Style) {
+	Colorscheme = scheme
+	cache, ok := colorCaches[name]
+	if !ok {
+		cache = make(map[string]tcell.Style)
+		colorCaches[name] = cache
+	}
+	colorCache = cache
+	if style, ok := scheme["default"]; ok {
+		DefStyle = style
+	} else {
+		DefStyle = tcell.StyleDefault
+	}
+}
+
+// defaultDarkColorscheme returns the hardcoded default dark colorscheme
+func defaultDarkColorscheme() map[string]tcell.Style {
+	def := tcell.StyleDefault.Foreground(tcell.ColorWhite).Background(tcell.ColorBlack)
 
 	// Hardcoded dark theme colors - simplified for micromini
-	Colorscheme["default"] = DefStyle
-	Colorscheme["comment"] = DefStyle.Foreground(tcell.ColorGray)
-	Colorscheme["comment.line"] = DefStyle.Foreground(tcell.ColorGray)
-	Colorscheme["comment.block"] = DefStyle.Foreground(tcell.ColorGray)
-	Colorscheme["constant"] = DefStyle.Foreground(tcell.ColorRed)
-	Colorscheme["constant.bool"] = DefStyle.Foreground(tcell.ColorRed)
-	Colorscheme["constant.number"] = DefStyle.Foreground(tcell.ColorRed)
-	Colorscheme["constant.string"] = DefStyle.Foreground(tcell.ColorYellow)
-	Colorscheme["identifier"] = DefStyle.Foreground(tcell.ColorWhite)
-	Colorscheme["identifier.function"] = DefStyle.Foreground(tcell.ColorBlue)
-	Colorscheme["identifier.class"] = DefStyle.Foreground(tcell.ColorBlue)
-	Colorscheme["statement"] = DefStyle.Foreground(tcell.ColorGreen)
-	Colorscheme["preproc"] = DefStyle.Foreground(tcell.ColorPurple)
-	Colorscheme["type"] = DefStyle.Foreground(tcell.ColorTeal)
-	Colorscheme["special"] = DefStyle.Foreground(tcell.ColorPurple)
-	Colorscheme["underlined"] = DefStyle.Underline(true)
-	Colorscheme["error"] = DefStyle.Foreground(tcell.ColorRed).Background(tcell.ColorWhite)
-	Colorscheme["todo"] = DefStyle.Foreground(tcell.ColorYellow).Bold(true)
-	Colorscheme["statusline"] = DefStyle.Reverse(true)
-	Colorscheme["tabbar"] = DefStyle.Reverse(true)
-	Colorscheme["indent-char"] = DefStyle.Foreground(tcell.ColorGray)
-	Colorscheme["line-number"] = DefStyle.Foreground(tcell.ColorGray)
-	Colorscheme["current-line-number"] = DefStyle.Foreground(tcell.ColorWhite).Bold(true)
-	Colorscheme["diff-added"] = DefStyle.Foreground(tcell.ColorGreen)
-	Colorscheme["diff-modified"] = DefStyle.Foreground(tcell.ColorYellow)
-	Colorscheme["diff-deleted"] = DefStyle.Foreground(tcell.ColorRed)
-	Colorscheme["gutter-error"] = DefStyle.Foreground(tcell.ColorRed)
-	Colorscheme["gutter-warning"] = DefStyle.Foreground(tcell.ColorYellow)
-	Colorscheme["cursor-line"] = DefStyle.Background(tcell.ColorNavy)
-	Colorscheme["color-column"] = DefStyle.Background(tcell.ColorNavy)
-	Colorscheme["ignore"] = DefStyle.Foreground(tcell.ColorGray)
-	Colorscheme["scrollbar"] = DefStyle.Foreground(tcell.ColorWhite).Background(tcell.ColorGray)
-	Colorscheme["divider"] = DefStyle.Foreground(tcell.ColorGray)
+	return map[string]tcell.Style{
+		"default":             def,
+		"comment":             def.Foreground(tcell.ColorGray),
+		"comment.line":        def.Foreground(tcell.ColorGray),
+		"comment.block":       def.Foreground(tcell.ColorGray),
+		"constant":            def.Foreground(tcell.ColorRed),
+		"constant.bool":       def.Foreground(tcell.ColorRed),
+		"constant.number":     def.Foreground(tcell.ColorRed),
+		"constant.string":     def.Foreground(tcell.ColorYellow),
+		"identifier":          def.Foreground(tcell.ColorWhite),
+		"identifier.function": def.Foreground(tcell.ColorBlue),
+		"identifier.class":    def.Foreground(tcell.ColorBlue),
+		"statement":           def.Foreground(tcell.ColorGreen),
+		"preproc":             def.Foreground(tcell.ColorPurple),
+		"type":                def.Foreground(tcell.ColorTeal),
+		"special":             def.Foreground(tcell.ColorPurple),
+		"underlined":          def.Underline(true),
+		"error":               def.Foreground(tcell.ColorRed).Background(tcell.ColorWhite),
+		"todo":                def.Foreground(tcell.ColorYellow).Bold(true),
+		"statusline":          def.Reverse(true),
+		"tabbar":              def.Reverse(true),
+		"indent-char":         def.Foreground(tcell.ColorGray),
+		"line-number":         def.Foreground(tcell.ColorGray),
+		"current-line-number": def.Foreground(tcell.ColorWhite).Bold(true),
+		"diff-added":          def.Foreground(tcell.ColorGreen),
+		"diff-modified":       def.Foreground(tcell.ColorYellow),
+		"diff-deleted":        def.Foreground(tcell.ColorRed),
+		"gutter-error":        def.Foreground(tcell.ColorRed),
+		"gutter-warning":      def.Foreground(tcell.ColorYellow),
+		"cursor-line":         def.Background(tcell.ColorNavy),
+		"color-column":        def.Background(tcell.ColorNavy),
+		"ignore":              def.Foreground(tcell.ColorGray),
+		"scrollbar":           def.Foreground(tcell.ColorWhite).Background(tcell.ColorGray),
+		"divider":             def.Foreground(tcell.ColorGray),
 
-	return nil
+		"statusline.suggestion.active": def.Bold(true),
+	}
 }
 
-// ParseColorscheme function removed - colorschemes are hardcoded in micromini
+// defaultLightColorscheme returns the hardcoded default light colorscheme
+func defaultLightColorscheme() map[string]tcell.Style {
+	def := tcell.StyleDefault.Foreground(tcell.ColorBlack).Background(tcell.ColorWhite)
+
+	return map[string]tcell.Style{
+		"default":             def,
+		"comment":             def.Foreground(tcell.ColorGray),
+		"comment.line":        def.Foreground(tcell.ColorGray),
+		"comment.block":       def.Foreground(tcell.ColorGray),
+		"constant":            def.Foreground(tcell.ColorMaroon),
+		"constant.bool":       def.Foreground(tcell.ColorMaroon),
+		"constant.number":     def.Foreground(tcell.ColorMaroon),
+		"constant.string":     def.Foreground(tcell.ColorOlive),
+		"identifier":          def.Foreground(tcell.ColorBlack),
+		"identifier.function": def.Foreground(tcell.ColorNavy),
+		"identifier.class":    def.Foreground(tcell.ColorNavy),
+		"statement":           def.Foreground(tcell.ColorGreen),
+		"preproc":             def.Foreground(tcell.ColorPurple),
+		"type":                def.Foreground(tcell.ColorTeal),
+		"special":             def.Foreground(tcell.ColorPurple),
+		"underlined":          def.Underline(true),
+		"error":               def.Foreground(tcell.ColorWhite).Background(tcell.ColorMaroon),
+		"todo":                def.Foreground(tcell.ColorOlive).Bold(true),
+		"statusline":          def.Reverse(true),
+		"tabbar":              def.Reverse(true),
+		"indent-char":         def.Foreground(tcell.ColorSilver),
+		"line-number":         def.Foreground(tcell.ColorGray),
+		"current-line-number": def.Foreground(tcell.ColorBlack).Bold(true),
+		"diff-added":          def.Foreground(tcell.ColorGreen),
+		"diff-modified":       def.Foreground(tcell.ColorOlive),
+		"diff-deleted":        def.Foreground(tcell.ColorMaroon),
+		"gutter-error":        def.Foreground(tcell.ColorMaroon),
+		"gutter-warning":      def.Foreground(tcell.ColorOlive),
+		"cursor-line":         def.Background(tcell.ColorSilver),
+		"color-column":        def.Background(tcell.ColorSilver),
+		"ignore":              def.Foreground(tcell.ColorGray),
+		"scrollbar":           def.Foreground(tcell.ColorBlack).Background(tcell.ColorSilver),
+		"divider":             def.Foreground(tcell.ColorGray),
+
+		"statusline.suggestion.active": def.Bold(true),
+	}
+}
+
+// highContrastColorscheme returns the hardcoded high-contrast colorscheme,
+// meant for low-vision users. Every group has a contrast ratio of at least
+// 4.5:1, the WCAG AA level for normal text
+func highContrastColorscheme() map[string]tcell.Style {
+	def := tcell.StyleDefault.Foreground(tcell.ColorWhite).Background(tcell.ColorBlack)
+
+	return map[string]tcell.Style{
+		"default":             def,
+		"comment":             def.Foreground(tcell.ColorSilver).Italic(true),
+		"comment.line":        def.Foreground(tcell.ColorSilver).Italic(true),
+		"comment.block":       def.Foreground(tcell.ColorSilver).Italic(true),
+		"constant":            def.Foreground(tcell.ColorFuchsia),
+		"constant.bool":       def.Foreground(tcell.ColorFuchsia),
+		"constant.number":     def.Foreground(tcell.ColorFuchsia),
+		"constant.string":     def.Foreground(tcell.ColorYellow),
+		"identifier":          def.Foreground(tcell.ColorWhite),
+		"identifier.function": def.Foreground(tcell.ColorAqua),
+		"identifier.class":    def.Foreground(tcell.ColorAqua),
+		"statement":           def.Foreground(tcell.ColorLime).Bold(true),
+		"preproc":             def.Foreground(tcell.ColorFuchsia).Bold(true),
+		"type":                def.Foreground(tcell.ColorAqua).Bold(true),
+		"special":             def.Foreground(tcell.ColorYellow).Bold(true),
+		"underlined":          def.Underline(true),
+		"error":               def.Foreground(tcell.ColorWhite).Background(tcell.ColorMaroon).Bold(true),
+		"todo":                def.Foreground(tcell.ColorBlack).Background(tcell.ColorYellow),
+		"statusline":          def.Reverse(true).Bold(true),
+		"tabbar":              def.Reverse(true),
+		"indent-char":         def.Foreground(tcell.ColorSilver),
+		"line-number":         def.Foreground(tcell.ColorSilver),
+		"current-line-number": def.Foreground(tcell.ColorYellow).Bold(true),
+		"diff-added":          def.Foreground(tcell.ColorLime),
+		"diff-modified":       def.Foreground(tcell.ColorYellow),
+		"diff-deleted":        def.Foreground(tcell.ColorRed),
+		"gutter-error":        def.Foreground(tcell.ColorRed).Bold(true),
+		"gutter-warning":      def.Foreground(tcell.ColorYellow).Bold(true),
+		"cursor-line":         def.Background(tcell.ColorNavy),
+		"color-column":        def.Background(tcell.ColorNavy),
+		"ignore":              def.Foreground(tcell.ColorSilver),
+		"scrollbar":           def.Foreground(tcell.ColorBlack).Background(tcell.ColorWhite),
+		"divider":             def.Foreground(tcell.ColorWhite),
+		"selection":           def.Foreground(tcell.ColorBlack).Background(tcell.ColorAqua),
+
+		"statusline.suggestion.active": def.Foreground(tcell.ColorBlack).Background(tcell.ColorYellow),
+	}
+}
+
+// ParseColorscheme parses the contents of a colorscheme file. Each line
+// links a group to a style, either as `group: style` or in the format of
+// micro's colorscheme files, `color-link group "style"`. Blank lines and
+// lines starting with # are ignored. The default group, if present, is the
+// base of the styles of all the other groups
+func ParseColorscheme(name, data string) (map[string]tcell.Style, error) {
+	type link struct {
+		group, style string
+	}
+	var links []link
+
+	for n, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var group, style string
+		if fields := strings.Fields(line); fields[0] == "color-link" && len(fields) >= 3 {
+			group = fields[1]
+			style = strings.Join(fields[2:], " ")
+		} else if i := strings.Index(line, ":"); i > 0 {
+			group = strings.TrimSpace(line[:i])
+			style = strings.TrimSpace(line[i+1:])
+		} else {
+			return nil, fmt.Errorf("Error parsing colorscheme %s: line %d: missing ':' after the group in '%s'", name, n+1, line)
+		}
+		style = strings.Trim(style, "\"")
+		if err := checkStyle(style); err != nil {
+			return nil, fmt.Errorf("Error parsing colorscheme %s: line %d: %v", name, n+1, err)
+		}
+		links = append(links, link{group, style})
+	}
+
+	// the other styles are relative to the default style
+	oldDef := DefStyle
+	defer func() { DefStyle = oldDef }()
+	for _, l := range links {
+		if l.group == "default" {
+			DefStyle = StringToStyle(l.style)
+		}
+	}
+
+	scheme := make(map[string]tcell.Style)
+	for _, l := range links {
+		scheme[l.group] = StringToStyle(l.style)
+	}
+	return scheme, nil
+}
 
 // StringToStyle returns a style from a string
 // The strings must be in the format "extra foregroundcolor,backgroundcolor"
 // The 'extra' can be bold, reverse, italic or underline
+// Only whole space-separated tokens before the final color spec are treated
+// as attributes, so a color spec is never mistaken for an attribute
 func StringToStyle(str string) tcell.Style {
-	var fg, bg string
-	spaceSplit := strings.Split(str, " ")
-	split := strings.Split(spaceSplit[len(spaceSplit)-1], ",")
-	if len(split) > 1 {
-		fg, bg = split[0], split[1]
-	} else {
-		fg = split[0]
-	}
-	fg = strings.TrimSpace(fg)
-	bg = strings.TrimSpace(bg)
+	attrs, fg, bg := splitStyle(str)
 
 	var fgColor, bgColor tcell.Color
 	var ok bool
@@ -119,20 +572,91 @@ func StringToStyle(str string) tcell.Style {
 		}
 	}
 
+	if !SupportsTrueColor() {
+		fgColor = NearestPaletteColor(fgColor)
+		bgColor = NearestPaletteColor(bgColor)
+	}
+
 	style := DefStyle.Foreground(fgColor).Background(bgColor)
-	if strings.Contains(str, "bold") {
-		style = style.Bold(true)
+	for _, attr := range attrs {
+		switch attr {
+		case "bold":
+			style = style.Bold(true)
+		case "italic":
+			style = style.Italic(true)
+		case "reverse":
+			style = style.Reverse(true)
+		case "underline":
+			style = style.Underline(true)
+		case "strikethrough":
+			style = style.StrikeThrough(true)
+		case "dim":
+			style = style.Dim(true)
+		case "blink":
+			style = style.Blink(true)
+		}
+	}
+	return style
+}
+
+// splitStyle splits a style string into its attributes and its foreground
+// and background colors, which are empty if they are not given
+func splitStyle(str string) ([]string, string, string) {
+	var fg, bg string
+	var tokens []string
+	for _, t := range splitOutsideParens(str, unicode.IsSpace) {
+		if t != "" {
+			tokens = append(tokens, t)
+		}
+	}
+	var attrs []string
+	spec := ""
+	if len(tokens) > 0 {
+		attrs = tokens[:len(tokens)-1]
+		spec = tokens[len(tokens)-1]
+		if isStyleAttribute(spec) {
+			// only attributes were given, e.g. "bold"
+			attrs = tokens
+			spec = ""
+		}
 	}
-	if strings.Contains(str, "italic") {
-		style = style.Italic(true)
+	split := splitOutsideParens(spec, func(r rune) bool { return r == ',' })
+	if len(split) > 1 {
+		fg, bg = split[0], split[1]
+	} else {
+		fg = split[0]
 	}
-	if strings.Contains(str, "reverse") {
-		style = style.Reverse(true)
+	return attrs, strings.TrimSpace(fg), strings.TrimSpace(bg)
+}
+
+// checkStyle returns an error for the first color or attribute of a style
+// string that StringToStyle would silently ignore
+func checkStyle(str string) error {
+	attrs, fg, bg := splitStyle(str)
+	for _, attr := range attrs {
+		if !isStyleAttribute(attr) {
+			return fmt.Errorf("invalid attribute '%s'", attr)
+		}
 	}
-	if strings.Contains(str, "underline") {
-		style = style.Underline(true)
+	for _, c := range []string{fg, bg} {
+		if c == "" || c == "default" {
+			continue
+		}
+		if _, ok := StringToColor(c); !ok {
+			return fmt.Errorf("invalid color '%s'", c)
+		}
 	}
-	return style
+	return nil
+}
+
+// isStyleAttribute returns true if the given token is one of the attributes
+// accepted by StringToStyle
+func isStyleAttribute(token string) bool {
+	switch token {
+	case "bold", "italic", "reverse", "underline", "strikethrough", "dim", "blink":
+		return true
+	}
+	return false
 }
 
 // StringToColor returns a tcell color from a string representation of a color
@@ -171,7 +695,9 @@ func StringToColor(str string) (tcell.Color, bool) {
 		return tcell.ColorAqua, true
 	case "brightwhite", "lightwhite":
 		return tcell.ColorWhite, true
-	case "default":
+	case "default", "term":
+		// in styles "default" is the color of the default group instead,
+		// while "term" always stands for the terminal's own color
 		return tcell.ColorDefault, true
 	default:
 		// Check if this is a 256 color
@@ -182,14 +708,173 @@ func StringToColor(str string) (tcell.Color, bool) {
 		if len(str) == 7 && str[0] == '#' {
 			return tcell.GetColor(str), true
 		}
+		if len(str) == 4 && str[0] == '#' {
+			return shortHexColor(str[1:])
+		}
+		if args, ok := colorFunc(str, "rgb"); ok {
+			return rgbColor(args)
+		}
+		if args, ok := colorFunc(str, "hsl"); ok {
+			return hslColor(args)
+		}
+		return tcell.ColorDefault, false
+	}
+}
+
+// shortHexColor expands a 3-digit hex color such as abc to #aabbcc
+func shortHexColor(hex string) (tcell.Color, bool) {
+	var rgb [3]int32
+	for i := 0; i < 3; i++ {
+		v, err := strconv.ParseUint(hex[i:i+1], 16, 8)
+		if err != nil {
+			return tcell.ColorDefault, false
+		}
+		rgb[i] = int32(v) * 0x11
+	}
+	return tcell.NewRGBColor(rgb[0], rgb[1], rgb[2]), true
+}
+
+// splitOutsideParens splits str around the runes for which sep returns
+// true, except inside parentheses
+func splitOutsideParens(str string, sep func(rune) bool) []string {
+	var fields []string
+	depth := 0
+	start := 0
+	for i, r := range str {
+		switch {
+		case r == '(':
+			depth++
+		case r == ')' && depth > 0:
+			depth--
+		case depth == 0 && sep(r):
+			fields = append(fields, str[start:i])
+			start = i + utf8.RuneLen(r)
+		}
+	}
+	return append(fields, str[start:])
+}
+
+// colorFunc parses a color in functional notation such as rgb(1, 2, 3) and
+// returns its comma-separated arguments with surrounding whitespace removed
+func colorFunc(str, name string) ([]string, bool) {
+	str = strings.TrimSpace(str)
+	if !strings.HasPrefix(str, name+"(") || !strings.HasSuffix(str, ")") {
+		return nil, false
+	}
+	args := strings.Split(str[len(name)+1:len(str)-1], ",")
+	for i := range args {
+		args[i] = strings.TrimSpace(args[i])
+	}
+	return args, true
+}
+
+// rgbColor returns the color for the arguments of rgb(r, g, b), each of
+// which must be between 0 and 255
+func rgbColor(args []string) (tcell.Color, bool) {
+	if len(args) != 3 {
 		return tcell.ColorDefault, false
 	}
+	var rgb [3]int32
+	for i, a := range args {
+		n, err := strconv.Atoi(a)
+		if err != nil || n < 0 || n > 255 {
+			return tcell.ColorDefault, false
+		}
+		rgb[i] = int32(n)
+	}
+	return tcell.NewRGBColor(rgb[0], rgb[1], rgb[2]), true
+}
+
+// hslColor returns the color for the arguments of hsl(h, s%, l%), where the
+// hue is between 0 and 360 and the saturation and lightness are percentages
+func hslColor(args []string) (tcell.Color, bool) {
+	if len(args) != 3 {
+		return tcell.ColorDefault, false
+	}
+	h, err := strconv.ParseFloat(args[0], 64)
+	if err != nil || h < 0 || h > 360 {
+		return tcell.ColorDefault, false
+	}
+	var sl [2]float64
+	for i, a := range args[1:] {
+		v, err := strconv.ParseFloat(strings.TrimSuffix(a, "%"), 64)
+		if err != nil || v < 0 || v > 100 {
+			return tcell.ColorDefault, false
+		}
+		sl[i] = v / 100
+	}
+	sat, light := sl[0], sl[1]
+
+	c := (1 - math.Abs(2*light-1)) * sat
+	hp := math.Mod(h, 360) / 60
+	x := c * (1 - math.Abs(math.Mod(hp, 2)-1))
+	var r, g, b float64
+	switch {
+	case hp < 1:
+		r, g, b = c, x, 0
+	case hp < 2:
+		r, g, b = x, c, 0
+	case hp < 3:
+		r, g, b = 0, c, x
+	case hp < 4:
+		r, g, b = 0, x, c
+	case hp < 5:
+		r, g, b = x, 0, c
+	default:
+		r, g, b = c, 0, x
+	}
+	m := light - c/2
+	to8 := func(v float64) int32 {
+		return int32(math.Round((v + m) * 255))
+	}
+	return tcell.NewRGBColor(to8(r), to8(g), to8(b)), true
+}
+
+// SupportsTrueColor returns whether 24-bit colors can be displayed, either
+// because the truecolor option is on or because the terminal advertises
+// support for them through COLORTERM or MICRO_TRUECOLOR
+func SupportsTrueColor() bool {
+	truecolor, _ := GetGlobalOption("truecolor").(string)
+	switch truecolor {
+	case "on":
+		return true
+	case "off":
+		return false
+	}
+	colorterm := os.Getenv("COLORTERM")
+	return colorterm == "truecolor" || colorterm == "24bit" || os.Getenv("MICRO_TRUECOLOR") == "1"
+}
+
+// paletteColors are the colors of the 256-color palette that have fixed
+// values. The first 16 colors are left out because terminals let the user
+// change them
+var paletteColors []tcell.Color
+
+// nearestColors caches the results of NearestPaletteColor
+var nearestColors = make(map[tcell.Color]tcell.Color)
+
+// NearestPaletteColor returns the color of the 256-color palette closest to
+// the given RGB color. Other colors are returned unchanged
+func NearestPaletteColor(c tcell.Color) tcell.Color {
+	if !c.IsRGB() {
+		return c
+	}
+	if nc, ok := nearestColors[c]; ok {
+		return nc
+	}
+	if paletteColors == nil {
+		for i := 16; i < 256; i++ {
+			paletteColors = append(paletteColors, tcell.PaletteColor(i))
+		}
+	}
+	nc := tcell.FindColor(c, paletteColors)
+	nearestColors[c] = nc
+	return nc
 }
 
 // GetColor256 returns the tcell color for a number between 0 and 255
+// The terminal's default color is not part of the palette and is named
+// "default" in colorschemes instead
 func GetColor256(color int) tcell.Color {
-	if color == 0 {
-		return tcell.ColorDefault
-	}
 	return tcell.PaletteColor(color)
 }
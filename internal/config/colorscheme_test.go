@@ -1,6 +1,7 @@
 package config
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/micro-editor/tcell/v2"
@@ -38,6 +39,67 @@ func TestMultiAttributesStringToStyle(t *testing.T) {
 	assert.NotEqual(t, 0, attr&tcell.AttrUnderline)
 }
 
+func TestAttributeSubstringStringToStyle(t *testing.T) {
+	s := StringToStyle("notbold boldblue,underlined")
+
+	_, _, attr := s.Decompose()
+
+	assert.Equal(t, tcell.AttrNone, attr&tcell.AttrBold)
+	assert.Equal(t, tcell.AttrNone, attr&tcell.AttrUnderline)
+
+	s = StringToStyle("cyan,bold")
+
+	fg, _, attr := s.Decompose()
+
+	assert.Equal(t, tcell.ColorTeal, fg)
+	assert.Equal(t, tcell.AttrNone, attr&tcell.AttrBold)
+}
+
+func TestDefaultColorsWithAttribute(t *testing.T) {
+	s := StringToStyle("reverse default,default")
+
+	fg, bg, attr := s.Decompose()
+	defFg, defBg, _ := DefStyle.Decompose()
+
+	assert.Equal(t, defFg, fg)
+	assert.Equal(t, defBg, bg)
+	assert.NotEqual(t, 0, attr&tcell.AttrReverse)
+
+	// unknown attribute tokens are ignored
+	s = StringToStyle("sparkly bold blue")
+
+	fg, _, attr = s.Decompose()
+
+	assert.Equal(t, tcell.ColorNavy, fg)
+	assert.NotEqual(t, 0, attr&tcell.AttrBold)
+}
+
+func TestTermColorStringToStyle(t *testing.T) {
+	defer func(s tcell.Style) { DefStyle = s }(DefStyle)
+	DefStyle = tcell.StyleDefault.Foreground(tcell.ColorWhite).Background(tcell.ColorNavy)
+
+	fg, bg, _ := StringToStyle("red,term").Decompose()
+	assert.Equal(t, tcell.ColorMaroon, fg)
+	assert.Equal(t, tcell.ColorDefault, bg)
+
+	// default still refers to the default group's colors
+	_, bg, _ = StringToStyle("red,default").Decompose()
+	assert.Equal(t, tcell.ColorNavy, bg)
+
+	fg, bg, _ = StringToStyle("term").Decompose()
+	assert.Equal(t, tcell.ColorDefault, fg)
+	assert.Equal(t, tcell.ColorNavy, bg)
+}
+
+func TestOnlyAttributesStringToStyle(t *testing.T) {
+	s := StringToStyle("bold underline")
+
+	_, _, attr := s.Decompose()
+
+	assert.NotEqual(t, 0, attr&tcell.AttrBold)
+	assert.NotEqual(t, 0, attr&tcell.AttrUnderline)
+}
+
 func TestColor256StringToStyle(t *testing.T) {
 	s := StringToStyle("128,60")
 
@@ -47,7 +109,22 @@ func TestColor256StringToStyle(t *testing.T) {
 	assert.Equal(t, tcell.Color60, bg)
 }
 
+func TestColor256Zero(t *testing.T) {
+	assert.Equal(t, tcell.PaletteColor(0), GetColor256(0))
+	assert.NotEqual(t, tcell.ColorDefault, GetColor256(0))
+
+	c, ok := StringToColor("0")
+	assert.True(t, ok)
+	assert.Equal(t, tcell.ColorBlack, c)
+
+	c, ok = StringToColor("default")
+	assert.True(t, ok)
+	assert.Equal(t, tcell.ColorDefault, c)
+}
+
 func TestColorHexStringToStyle(t *testing.T) {
+	t.Setenv("COLORTERM", "truecolor")
+
 	s := StringToStyle("#deadbe,#ef1234")
 
 	fg, bg, _ := s.Decompose()
@@ -71,3 +148,391 @@ func TestHardcodedColorscheme(t *testing.T) {
 	fg, _, _ := Colorscheme["comment"].Decompose()
 	assert.Equal(t, tcell.ColorGray, fg)
 }
+
+func TestParseColorscheme(t *testing.T) {
+	scheme, err := ParseColorscheme("test", `
+# a small test scheme
+default: brightwhite,black
+
+comment: "bold green"
+color-link constant "red,blue"
+  statusline:   reverse
+`)
+	assert.NoError(t, err)
+	assert.Len(t, scheme, 4)
+
+	fg, bg, _ := scheme["default"].Decompose()
+	assert.Equal(t, tcell.ColorWhite, fg)
+	assert.Equal(t, tcell.ColorBlack, bg)
+
+	// groups without a background use the default one
+	fg, bg, attr := scheme["comment"].Decompose()
+	assert.Equal(t, tcell.ColorGreen, fg)
+	assert.Equal(t, tcell.ColorBlack, bg)
+	assert.NotEqual(t, 0, attr&tcell.AttrBold)
+
+	fg, bg, _ = scheme["constant"].Decompose()
+	assert.Equal(t, tcell.ColorMaroon, fg)
+	assert.Equal(t, tcell.ColorNavy, bg)
+
+	_, _, attr = scheme["statusline"].Decompose()
+	assert.NotEqual(t, 0, attr&tcell.AttrReverse)
+
+	_, err = ParseColorscheme("test", "comment green")
+	assert.Error(t, err)
+}
+
+func TestParseColorschemeErrors(t *testing.T) {
+	_, err := ParseColorscheme("test", "# colors\ndefault: white,black\n\ncomment: redd\n")
+	assert.EqualError(t, err, "Error parsing colorscheme test: line 4: invalid color 'redd'")
+
+	_, err = ParseColorscheme("test", "default: white,black\ncomment green\n")
+	assert.EqualError(t, err, "Error parsing colorscheme test: line 2: missing ':' after the group in 'comment green'")
+
+	_, err = ParseColorscheme("test", `color-link comment "blod green"`)
+	assert.EqualError(t, err, "Error parsing colorscheme test: line 1: invalid attribute 'blod'")
+
+	_, err = ParseColorscheme("test", "statusline: reverse white,nope")
+	assert.EqualError(t, err, "Error parsing colorscheme test: line 1: invalid color 'nope'")
+}
+
+func TestInitColorschemeFromRuntime(t *testing.T) {
+	InitRuntimeFiles(false)
+	AddRuntimeFile(RTColorscheme, memoryFile{"test", []byte("default: red,white\ncomment: blue\n")})
+	GlobalSettings = map[string]interface{}{"colorscheme": "test"}
+	defer func() {
+		GlobalSettings = nil
+		InitRuntimeFiles(false)
+		InitColorscheme()
+	}()
+
+	assert.NoError(t, InitColorscheme())
+	fg, bg, _ := Colorscheme["comment"].Decompose()
+	assert.Equal(t, tcell.ColorNavy, fg)
+	assert.Equal(t, tcell.ColorSilver, bg)
+	assert.Equal(t, Colorscheme["default"], DefStyle)
+
+	// unknown colorschemes are reported and fall back to the hardcoded one
+	GlobalSettings["colorscheme"] = "missing"
+	err := InitColorscheme()
+	assert.True(t, errors.Is(err, errUnknownColorscheme))
+	assert.EqualError(t, err, "Unknown colorscheme missing")
+	fg, _, _ = Colorscheme["comment"].Decompose()
+	assert.Equal(t, tcell.ColorGray, fg)
+}
+
+func TestSetColorscheme(t *testing.T) {
+	defer SetColorscheme("default")
+
+	assert.NoError(t, SetColorscheme("default-light"))
+	_, bg, _ := Colorscheme["default"].Decompose()
+	assert.Equal(t, tcell.ColorWhite, bg)
+	assert.Equal(t, Colorscheme["default"], DefStyle)
+
+	assert.NoError(t, SetColorscheme("default-dark"))
+	_, bg, _ = Colorscheme["default"].Decompose()
+	assert.Equal(t, tcell.ColorBlack, bg)
+
+	light := Colorscheme
+	assert.Error(t, SetColorscheme("nonexistent"))
+	assert.Equal(t, light, Colorscheme)
+}
+
+func TestFunctionalColors(t *testing.T) {
+	t.Setenv("COLORTERM", "truecolor")
+
+	c, ok := StringToColor("rgb(255,128,0)")
+	assert.True(t, ok)
+	assert.Equal(t, tcell.NewRGBColor(255, 128, 0), c)
+
+	c, ok = StringToColor("rgb( 1 , 2 ,3 )")
+	assert.True(t, ok)
+	assert.Equal(t, tcell.NewRGBColor(1, 2, 3), c)
+
+	c, ok = StringToColor("hsl(0, 100%, 50%)")
+	assert.True(t, ok)
+	assert.Equal(t, tcell.NewRGBColor(255, 0, 0), c)
+
+	c, ok = StringToColor("hsl(120, 100%, 25%)")
+	assert.True(t, ok)
+	assert.Equal(t, tcell.NewRGBColor(0, 128, 0), c)
+
+	c, ok = StringToColor("hsl(240, 0%, 100%)")
+	assert.True(t, ok)
+	assert.Equal(t, tcell.NewRGBColor(255, 255, 255), c)
+
+	for _, bad := range []string{"rgb(256,0,0)", "rgb(-1,0,0)", "rgb(1,2)", "rgb(a,b,c)",
+		"hsl(361,50%,50%)", "hsl(0,101%,50%)", "hsl(0,50%)", "rgb(1,2,3"} {
+		c, ok = StringToColor(bad)
+		assert.False(t, ok, bad)
+		assert.Equal(t, tcell.ColorDefault, c, bad)
+	}
+
+	fg, bg, attr := StringToStyle("bold rgb(1, 2, 3),hsl(0, 100%, 50%)").Decompose()
+	assert.Equal(t, tcell.NewRGBColor(1, 2, 3), fg)
+	assert.Equal(t, tcell.NewRGBColor(255, 0, 0), bg)
+	assert.NotEqual(t, 0, attr&tcell.AttrBold)
+}
+
+func TestShortHexColor(t *testing.T) {
+	c, ok := StringToColor("#f00")
+	assert.True(t, ok)
+	assert.Equal(t, tcell.NewRGBColor(255, 0, 0), c)
+
+	c, ok = StringToColor("#abc")
+	assert.True(t, ok)
+	assert.Equal(t, tcell.GetColor("#aabbcc"), c)
+
+	c, ok = StringToColor("#zzz")
+	assert.False(t, ok)
+	assert.Equal(t, tcell.ColorDefault, c)
+}
+
+func TestExtraAttributesStringToStyle(t *testing.T) {
+	s := StringToStyle("strikethrough dim blink red")
+	fg, _, attr := s.Decompose()
+	assert.Equal(t, tcell.ColorMaroon, fg)
+	assert.NotEqual(t, 0, attr&tcell.AttrStrikeThrough)
+	assert.NotEqual(t, 0, attr&tcell.AttrDim)
+	assert.NotEqual(t, 0, attr&tcell.AttrBlink)
+	assert.Equal(t, tcell.AttrMask(0), attr&tcell.AttrBold)
+
+	_, _, attr = StringToStyle("bold blink").Decompose()
+	assert.NotEqual(t, 0, attr&tcell.AttrBold)
+	assert.NotEqual(t, 0, attr&tcell.AttrBlink)
+	assert.Equal(t, tcell.AttrMask(0), attr&tcell.AttrDim)
+}
+
+func TestGetColorCache(t *testing.T) {
+	defer SetColorscheme("default")
+
+	assert.NoError(t, SetColorscheme("default-dark"))
+	dark := GetColor("constant.number.hex")
+	assert.Equal(t, Colorscheme["constant.number"], dark)
+
+	assert.NoError(t, SetColorscheme("default-light"))
+	light := GetColor("constant.number.hex")
+	assert.Equal(t, Colorscheme["constant.number"], light)
+	assert.NotEqual(t, dark, light)
+}
+
+func BenchmarkGetColor(b *testing.B) {
+	SetColorscheme("default")
+	for i := 0; i < b.N; i++ {
+		GetColor("constant.number.hex")
+	}
+}
+
+func TestValidateColorscheme(t *testing.T) {
+	scheme := map[string]tcell.Style{
+		"comment":              tcell.StyleDefault,
+		"commnt":               tcell.StyleDefault,
+		"constant.string.char": tcell.StyleDefault,
+		"hlsearch":             tcell.StyleDefault,
+	}
+	warnings := ValidateColorscheme(scheme)
+	assert.Equal(t, 1, len(warnings))
+	assert.Contains(t, warnings[0], "commnt")
+
+	InitRuntimeFiles(false)
+	AddRuntimeFile(RTColorscheme, memoryFile{"typo", []byte("default: red\ncommnt: blue\n")})
+	defer func() {
+		InitRuntimeFiles(false)
+		SetColorscheme("default")
+	}()
+
+	// the colorscheme is still used, but the typo is reported
+	err := SetColorscheme("typo")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "commnt")
+	fg, _, _ := Colorscheme["commnt"].Decompose()
+	assert.Equal(t, tcell.ColorNavy, fg)
+}
+
+func TestNearestPaletteColor(t *testing.T) {
+	assert.Equal(t, tcell.PaletteColor(196), NearestPaletteColor(tcell.NewRGBColor(255, 0, 0)))
+	assert.Equal(t, tcell.PaletteColor(16), NearestPaletteColor(tcell.NewRGBColor(0, 0, 0)))
+	assert.Equal(t, tcell.PaletteColor(231), NearestPaletteColor(tcell.NewRGBColor(255, 255, 255)))
+	assert.Equal(t, tcell.PaletteColor(208), NearestPaletteColor(tcell.NewRGBColor(255, 135, 0)))
+	// palette colors are left alone
+	assert.Equal(t, tcell.ColorMaroon, NearestPaletteColor(tcell.ColorMaroon))
+	assert.Equal(t, tcell.ColorDefault, NearestPaletteColor(tcell.ColorDefault))
+}
+
+func TestStringToStyleDowngrade(t *testing.T) {
+	t.Setenv("COLORTERM", "")
+	t.Setenv("MICRO_TRUECOLOR", "")
+	fg, _, _ := StringToStyle("#ff0000").Decompose()
+	assert.Equal(t, tcell.PaletteColor(196), fg)
+
+	t.Setenv("COLORTERM", "truecolor")
+	assert.True(t, SupportsTrueColor())
+	fg, _, _ = StringToStyle("#ff0000").Decompose()
+	assert.Equal(t, tcell.NewRGBColor(255, 0, 0), fg)
+}
+
+func TestClearColorCache(t *testing.T) {
+	t.Setenv("COLORTERM", "")
+	t.Setenv("MICRO_TRUECOLOR", "")
+	GlobalSettings = map[string]interface{}{"truecolor": "off"}
+	defer func() {
+		GlobalSettings = nil
+		ClearColorCache()
+	}()
+	ClearColorCache()
+
+	fg, _, _ := GetColor("#ff0000").Decompose()
+	assert.Equal(t, tcell.PaletteColor(196), fg)
+
+	// the cached style is kept until the cache is cleared
+	GlobalSettings["truecolor"] = "on"
+	fg, _, _ = GetColor("#ff0000").Decompose()
+	assert.Equal(t, tcell.PaletteColor(196), fg)
+	ClearColorCache()
+	fg, _, _ = GetColor("#ff0000").Decompose()
+	assert.Equal(t, tcell.NewRGBColor(255, 0, 0), fg)
+}
+
+func TestFiletypeColorscheme(t *testing.T) {
+	assert.NoError(t, SetColorscheme("default-dark"))
+	defer func() {
+		SetFiletypeColorscheme("markdown", "")
+		SetFiletypeColorscheme("go", "")
+		SetColorscheme("default")
+	}()
+	dark := GetColor("comment")
+
+	SetFiletypeColorscheme("markdown", "default-light")
+	SetFiletypeColorscheme("go", "nonexistent")
+
+	UseFiletypeColorscheme("markdown")
+	light := GetColor("comment")
+	assert.NotEqual(t, dark, light)
+	_, bg, _ := DefStyle.Decompose()
+	assert.Equal(t, tcell.ColorWhite, bg)
+
+	// unknown colorschemes and filetypes without an override use the global one
+	UseFiletypeColorscheme("go")
+	assert.Equal(t, dark, GetColor("comment"))
+	UseFiletypeColorscheme("markdown")
+	UseFiletypeColorscheme("c")
+	assert.Equal(t, dark, GetColor("comment"))
+
+	UseFiletypeColorscheme("markdown")
+	UseGlobalColorscheme()
+	assert.Equal(t, dark, GetColor("comment"))
+	_, bg, _ = DefStyle.Decompose()
+	assert.Equal(t, tcell.ColorBlack, bg)
+}
+
+func TestFiletypeColorschemeCache(t *testing.T) {
+	assert.NoError(t, SetColorscheme("default-dark"))
+	defer func() {
+		SetFiletypeColorscheme("markdown", "")
+		SetColorscheme("default")
+	}()
+	SetFiletypeColorscheme("markdown", "default-light")
+
+	GetColor("comment")
+	global := colorCache
+	UseFiletypeColorscheme("markdown")
+	GetColor("comment")
+	light := colorCache
+
+	// switching back and forth reuses each colorscheme's cache
+	UseGlobalColorscheme()
+	assert.Contains(t, colorCache, "comment")
+	colorCache["sentinel"] = tcell.StyleDefault
+	UseFiletypeColorscheme("markdown")
+	assert.Contains(t, colorCache, "comment")
+	UseGlobalColorscheme()
+	assert.Contains(t, colorCache, "sentinel")
+	assert.Contains(t, light, "comment")
+	assert.Contains(t, global, "sentinel")
+
+	// reloading a colorscheme drops only its cache
+	SetFiletypeColorscheme("markdown", "default-light")
+	assert.Contains(t, colorCache, "sentinel")
+	UseFiletypeColorscheme("markdown")
+	assert.NotContains(t, colorCache, "comment")
+
+	UseGlobalColorscheme()
+	ClearColorCache()
+	assert.NotContains(t, colorCache, "sentinel")
+}
+
+func TestCurrentColorschemeName(t *testing.T) {
+	GlobalSettings = map[string]interface{}{"colorscheme": "default-light"}
+	defer func() {
+		GlobalSettings = nil
+		SetColorscheme("default")
+	}()
+
+	assert.NoError(t, InitColorscheme())
+	assert.Equal(t, "default-light", CurrentColorschemeName())
+
+	assert.NoError(t, SetColorscheme("default-dark"))
+	assert.Equal(t, "default-dark", CurrentColorschemeName())
+
+	// failing to switch keeps the old name
+	assert.Error(t, SetColorscheme("nonexistent"))
+	assert.Equal(t, "default-dark", CurrentColorschemeName())
+
+	GlobalSettings["colorscheme"] = "nonexistent"
+	assert.Error(t, InitColorscheme())
+	assert.Equal(t, "default", CurrentColorschemeName())
+}
+
+func TestMergeColorscheme(t *testing.T) {
+	defer SetColorscheme("default")
+	assert.NoError(t, SetColorscheme("default-light"))
+	light := defaultLightColorscheme()
+
+	// a cached lookup must not survive the merge
+	GetColor("comment.todo")
+
+	bright := tcell.StyleDefault.Foreground(tcell.ColorYellow).Bold(true)
+	MergeColorscheme(map[string]tcell.Style{"comment": bright})
+	assert.Equal(t, bright, GetColor("comment"))
+	assert.Equal(t, bright, GetColor("comment.todo"))
+	for group, style := range light {
+		if group != "comment" {
+			assert.Equal(t, style, Colorscheme[group], group)
+		}
+	}
+	assert.Equal(t, "default-light", CurrentColorschemeName())
+
+	// the overrides stay when switching back from a filetype colorscheme
+	SetFiletypeColorscheme("go", "default-dark")
+	defer SetFiletypeColorscheme("go", "")
+	UseFiletypeColorscheme("go")
+	assert.NotEqual(t, bright, GetColor("comment"))
+	UseGlobalColorscheme()
+	assert.Equal(t, bright, GetColor("comment"))
+
+	ResetColorscheme()
+	assert.Equal(t, defaultDarkColorscheme()["comment"], GetColor("comment"))
+	assert.Equal(t, "default", CurrentColorschemeName())
+}
+
+func TestContrastRatio(t *testing.T) {
+	assert.InDelta(t, 21, ContrastRatio(tcell.ColorWhite, tcell.ColorBlack), 0.01)
+	assert.InDelta(t, 21, ContrastRatio(tcell.ColorBlack, tcell.ColorWhite), 0.01)
+	assert.InDelta(t, 1, ContrastRatio(tcell.ColorRed, tcell.ColorRed), 0.01)
+	assert.Less(t, ContrastRatio(tcell.NewRGBColor(100, 100, 100), tcell.NewRGBColor(110, 110, 110)), 1.5)
+	assert.Equal(t, float64(0), ContrastRatio(tcell.ColorDefault, tcell.ColorBlack))
+
+	for group, style := range highContrastColorscheme() {
+		fg, bg, _ := style.Decompose()
+		assert.GreaterOrEqual(t, ContrastRatio(fg, bg), 4.5, group)
+	}
+	assert.Empty(t, ValidateColorscheme(highContrastColorscheme()))
+
+	def := tcell.StyleDefault.Foreground(tcell.ColorWhite).Background(tcell.ColorBlack)
+	warnings := ValidateColorscheme(map[string]tcell.Style{
+		"default":     def,
+		"comment":     def.Foreground(tcell.PaletteColor(234)),
+		"indent-char": def.Foreground(tcell.PaletteColor(234)),
+	})
+	assert.Equal(t, []string{`low contrast in group "comment" (1.2:1)`}, warnings)
+}
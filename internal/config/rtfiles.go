@@ -1,9 +1,16 @@
 package config
 
 import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
 	"errors"
+	"io"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
 
 	rt "github.com/zyedidia/micro/v2/runtime"
 )
@@ -13,10 +20,11 @@ const (
 	RTHelp         = 1
 	RTSyntaxHeader = 2
 	RTPlugin       = 3 // Stub for tests - plugins removed
+	RTColorscheme  = 4
 )
 
 var (
-	NumTypes = 4 // How many filetypes are there (including RTPlugin stub for tests)
+	NumTypes = 5 // How many filetypes are there (including RTPlugin stub for tests)
 )
 
 type RTFiletype int
@@ -56,12 +64,24 @@ type realFile string
 // some asset file
 type assetFile string
 
+// a file stored in a zip archive
+type zipFile struct {
+	archive string
+	entry   string
+}
+
 // a file with the data stored in memory
 type memoryFile struct {
 	name string
 	data []byte
 }
 
+// NewMemoryRuntimeFile returns a runtime file with the given name whose
+// data is held in memory. It can be registered with AddRuntimeFile
+func NewMemoryRuntimeFile(name string, data []byte) RuntimeFile {
+	return memoryFile{name, data}
+}
+
 func (mf memoryFile) Name() string {
 	return mf.name
 }
@@ -78,13 +98,50 @@ func (rf realFile) Data() ([]byte, error) {
 	return os.ReadFile(string(rf))
 }
 
+// readAsset and readAssetDir read the embedded runtime assets
+var (
+	readAsset    = rt.Asset
+	readAssetDir = rt.AssetDir
+)
+
 func (af assetFile) Name() string {
-	fn := filepath.Base(string(af))
+	fn := strings.TrimSuffix(filepath.Base(string(af)), ".gz")
 	return fn[:len(fn)-len(filepath.Ext(fn))]
 }
 
+// Data returns the content of the asset, decompressed if the asset is
+// gzipped
 func (af assetFile) Data() ([]byte, error) {
-	return rt.Asset(string(af))
+	data, err := readAsset(string(af))
+	if err != nil || !strings.HasSuffix(string(af), ".gz") {
+		return data, err
+	}
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+func (zf zipFile) Name() string {
+	fn := path.Base(zf.entry)
+	return fn[:len(fn)-len(path.Ext(fn))]
+}
+
+func (zf zipFile) Data() ([]byte, error) {
+	r, err := zip.OpenReader(zf.archive)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	f, err := r.Open(zf.entry)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
 }
 
 // AddRuntimeFile registers a file for the given filetype
@@ -92,35 +149,147 @@ func AddRuntimeFile(fileType RTFiletype, file RuntimeFile) {
 	allFiles[fileType] = append(allFiles[fileType], file)
 }
 
-// AddRealRuntimeFile registers a file for the given filetype
+// AddRealRuntimeFile registers a file for the given filetype. A file on disk
+// that is already registered, possibly through a different path to it, is
+// not registered again
 func AddRealRuntimeFile(fileType RTFiletype, file RuntimeFile) {
+	if rf, ok := file.(realFile); ok {
+		for _, f := range realFiles[fileType] {
+			if other, ok := f.(realFile); ok && samePath(string(rf), string(other)) {
+				return
+			}
+		}
+	}
 	allFiles[fileType] = append(allFiles[fileType], file)
 	realFiles[fileType] = append(realFiles[fileType], file)
 }
 
+// RemoveRuntimeFile unregisters all files of the given filetype with the
+// given name and returns whether any file was removed
+func RemoveRuntimeFile(fileType RTFiletype, name string) bool {
+	var removed bool
+	allFiles[fileType], removed = removeFiles(allFiles[fileType], name)
+	realFiles[fileType], _ = removeFiles(realFiles[fileType], name)
+	return removed
+}
+
+// removeFiles returns a copy of files without the files with the given name,
+// so that slices returned by ListRuntimeFiles are not modified
+func removeFiles(files []RuntimeFile, name string) ([]RuntimeFile, bool) {
+	kept := make([]RuntimeFile, 0, len(files))
+	for _, f := range files {
+		if f.Name() != name {
+			kept = append(kept, f)
+		}
+	}
+	return kept, len(kept) != len(files)
+}
+
+// samePath returns whether the paths a and b refer to the same location
+func samePath(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	if errA != nil || errB != nil {
+		return filepath.Clean(a) == filepath.Clean(b)
+	}
+	return absA == absB
+}
+
 // AddRuntimeFilesFromDirectory registers each file from the given directory for
-// the filetype which matches the file-pattern
-func AddRuntimeFilesFromDirectory(fileType RTFiletype, directory, pattern string) {
-	files, _ := os.ReadDir(directory)
+// the filetype which matches the file-pattern. A directory that does not
+// exist is not an error
+func AddRuntimeFilesFromDirectory(fileType RTFiletype, directory, pattern string) error {
+	files, err := os.ReadDir(directory)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
 	for _, f := range files {
 		if ok, _ := filepath.Match(pattern, f.Name()); !f.IsDir() && ok {
 			fullPath := filepath.Join(directory, f.Name())
 			AddRealRuntimeFile(fileType, realFile(fullPath))
 		}
 	}
+	return nil
+}
+
+// AddRuntimeFilesFromDirectoryRecursive registers each file from the given
+// directory and its subdirectories for the filetype which matches the
+// file-pattern. If several files have the same name, the one closest to the
+// top of the directory is used. A directory that does not exist is not an
+// error; otherwise the first error met while walking the directories is
+// returned, after registering all the files that could be read
+func AddRuntimeFilesFromDirectoryRecursive(fileType RTFiletype, directory, pattern string) error {
+	depth := func(p string) int {
+		return strings.Count(p, string(filepath.Separator))
+	}
+
+	paths := make(map[string]string)
+	var names []string
+	var walkErr error
+	filepath.WalkDir(directory, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if walkErr == nil && !(p == directory && errors.Is(err, fs.ErrNotExist)) {
+				walkErr = err
+			}
+			return nil
+		}
+		if p == directory && !d.IsDir() {
+			walkErr = &fs.PathError{Op: "readdir", Path: p, Err: errors.New("not a directory")}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if ok, _ := filepath.Match(pattern, d.Name()); !ok {
+			return nil
+		}
+		name := realFile(p).Name()
+		prev, ok := paths[name]
+		if !ok {
+			names = append(names, name)
+		} else if depth(prev) <= depth(p) {
+			return nil
+		}
+		paths[name] = p
+		return nil
+	})
+
+	for _, name := range names {
+		AddRealRuntimeFile(fileType, realFile(paths[name]))
+	}
+	return walkErr
+}
+
+// AddRuntimeFilesFromZip registers each file from the given zip archive for
+// the filetype whose name matches the file-pattern. The files are read from
+// the archive when their data is requested
+func AddRuntimeFilesFromZip(fileType RTFiletype, zipPath, pattern string) error {
+	r, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	for _, f := range r.File {
+		if ok, _ := path.Match(pattern, path.Base(f.Name)); !f.FileInfo().IsDir() && ok {
+			AddRuntimeFile(fileType, zipFile{zipPath, f.Name})
+		}
+	}
+	return nil
 }
 
 // AddRuntimeFilesFromAssets registers each file from the given asset-directory for
-// the filetype which matches the file-pattern
+// the filetype which matches the file-pattern. Gzipped assets are matched
+// without their .gz extension
 func AddRuntimeFilesFromAssets(fileType RTFiletype, directory, pattern string) {
-	files, err := rt.AssetDir(directory)
+	files, err := readAssetDir(directory)
 	if err != nil {
 		return
 	}
 
 assetLoop:
 	for _, f := range files {
-		if ok, _ := filepath.Match(pattern, f); ok {
+		if ok, _ := filepath.Match(pattern, strings.TrimSuffix(f, ".gz")); ok {
 			af := assetFile(filepath.Join(directory, f))
 			for _, rf := range realFiles[fileType] {
 				if af.Name() == rf.Name() {
@@ -143,6 +312,25 @@ func FindRuntimeFile(fileType RTFiletype, name string) RuntimeFile {
 	return nil
 }
 
+// FindRuntimeFileFold finds a runtime file of the given filetype whose name
+// matches the given one regardless of case, preferring a file whose name
+// matches exactly. The name may include the file's extension, as in
+// "Go.yaml". Returns nil if no file was found
+func FindRuntimeFileFold(fileType RTFiletype, name string) RuntimeFile {
+	if f := FindRuntimeFile(fileType, name); f != nil {
+		return f
+	}
+	for _, f := range ListRuntimeFiles(fileType) {
+		if strings.EqualFold(f.Name(), name) {
+			return f
+		}
+	}
+	if ext := filepath.Ext(name); ext != "" {
+		return FindRuntimeFileFold(fileType, strings.TrimSuffix(name, ext))
+	}
+	return nil
+}
+
 // ListRuntimeFiles lists all known runtime files for the given filetype
 func ListRuntimeFiles(fileType RTFiletype) []RuntimeFile {
 	return allFiles[fileType]
@@ -154,22 +342,36 @@ func ListRealRuntimeFiles(fileType RTFiletype) []RuntimeFile {
 	return realFiles[fileType]
 }
 
-// InitRuntimeFiles initializes all assets files and the config directory.
+// InitRuntimeFiles initializes all assets files and the config directory,
+// including the subdirectories of its runtime directories.
 // If `user` is false, InitRuntimeFiles ignores the config directory and
-// initializes asset files only.
-func InitRuntimeFiles(user bool) {
+// initializes asset files only. It returns the first error encountered
+// while reading the config directory, but still loads all the files it can.
+// A config directory without runtime subdirectories is not an error.
+func InitRuntimeFiles(user bool) error {
+	var err error
 	add := func(fileType RTFiletype, dir, pattern string) {
 		if user {
-			AddRuntimeFilesFromDirectory(fileType, filepath.Join(ConfigDir, dir), pattern)
+			if e := AddRuntimeFilesFromDirectoryRecursive(fileType, filepath.Join(ConfigDir, dir), pattern); e != nil && err == nil {
+				err = e
+			}
 		}
 		AddRuntimeFilesFromAssets(fileType, filepath.Join("runtime", dir), pattern)
 	}
 
 	initRuntimeVars()
 
+	if user {
+		if _, e := os.Stat(ConfigDir); e != nil {
+			err = e
+		}
+	}
+
 	add(RTSyntax, "syntax", "*.yaml")
 	add(RTSyntaxHeader, "syntax", "*.hdr")
 	add(RTHelp, "help", "*.md")
+	add(RTColorscheme, "colorschemes", "*.micro")
+	return err
 }
 
 // InitPlugins is a no-op in micromini since plugins are removed
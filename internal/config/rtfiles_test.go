@@ -1,7 +1,14 @@
 package config
 
 import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -40,3 +47,271 @@ func TestFindFile(t *testing.T) {
 	e := FindRuntimeFile(RTSyntax, "foobar")
 	assert.Nil(t, e)
 }
+
+func TestRemoveFile(t *testing.T) {
+	AddRuntimeFile(RTHelp, memoryFile{"removeme", []byte("help\n")})
+	AddRealRuntimeFile(RTSyntax, memoryFile{"removeme", []byte("syntax\n")})
+	assert.NotNil(t, FindRuntimeFile(RTHelp, "removeme"))
+
+	assert.True(t, RemoveRuntimeFile(RTHelp, "removeme"))
+	assert.Nil(t, FindRuntimeFile(RTHelp, "removeme"))
+	assert.False(t, RemoveRuntimeFile(RTHelp, "removeme"))
+
+	// files of other types are left alone
+	assert.NotNil(t, FindRuntimeFile(RTSyntax, "removeme"))
+	assert.True(t, RemoveRuntimeFile(RTSyntax, "removeme"))
+	for _, f := range ListRuntimeFiles(RTSyntax) {
+		assert.NotEqual(t, "removeme", f.Name())
+	}
+	for _, f := range ListRealRuntimeFiles(RTSyntax) {
+		assert.NotEqual(t, "removeme", f.Name())
+	}
+	assert.NotNil(t, FindRuntimeFile(RTSyntax, "go"))
+}
+
+func TestWatchRuntimeFiles(t *testing.T) {
+	oldDir, oldInterval, oldDebounce := ConfigDir, watchInterval, watchDebounce
+	ConfigDir = t.TempDir()
+	watchInterval, watchDebounce = 10*time.Millisecond, 30*time.Millisecond
+	defer func() {
+		ConfigDir, watchInterval, watchDebounce = oldDir, oldInterval, oldDebounce
+		InitRuntimeFiles(false)
+	}()
+
+	syntaxDir := filepath.Join(ConfigDir, "syntax")
+	assert.NoError(t, os.Mkdir(syntaxDir, 0755))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	reloads := make(chan func(), 8)
+	done := make(chan bool)
+	reloaded := false
+	go func() {
+		WatchRuntimeFiles(ctx, func(f func()) { reloads <- f }, func(err error) {
+			assert.NoError(t, err)
+			reloaded = true
+		})
+		done <- true
+	}()
+
+	// give the watcher time to take its first snapshot
+	time.Sleep(20 * time.Millisecond)
+	assert.NoError(t, os.WriteFile(filepath.Join(syntaxDir, "watched.yaml"), []byte("filetype: watched\n"), 0644))
+
+	// the reload runs here, as it would on the main event loop
+	waitReload := func() {
+		reloaded = false
+		select {
+		case f := <-reloads:
+			f()
+		case <-time.After(2 * time.Second):
+			t.Fatal("the reload was not posted")
+		}
+		assert.True(t, reloaded)
+	}
+	loaded := func(name string) bool {
+		for _, f := range ListRealRuntimeFiles(RTSyntax) {
+			if f.Name() == name {
+				return true
+			}
+		}
+		return false
+	}
+	waitReload()
+	assert.True(t, loaded("watched"))
+
+	// files in subdirectories are watched too
+	nested := filepath.Join(syntaxDir, "c-family")
+	assert.NoError(t, os.Mkdir(nested, 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(nested, "nested.yaml"), []byte("filetype: nested\n"), 0644))
+	waitReload()
+	assert.True(t, loaded("nested"))
+
+	cancel()
+	<-done
+	assert.Equal(t, 0, len(reloads))
+}
+
+func TestAddFilesFromZip(t *testing.T) {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	files := map[string]string{
+		"syntax/zipped.yaml":  "filetype: zipped\n",
+		"syntax/other.yaml":   "filetype: other\n",
+		"syntax/readme.txt":   "not a syntax file\n",
+		"colorschemes/x.yaml": "filetype: nested\n",
+	}
+	for name, data := range files {
+		f, err := w.Create(name)
+		assert.NoError(t, err)
+		f.Write([]byte(data))
+	}
+	assert.NoError(t, w.Close())
+
+	archive := filepath.Join(t.TempDir(), "bundle.zip")
+	assert.NoError(t, os.WriteFile(archive, buf.Bytes(), 0644))
+	defer InitRuntimeFiles(false)
+
+	assert.NoError(t, AddRuntimeFilesFromZip(RTSyntax, archive, "*.yaml"))
+
+	f := FindRuntimeFile(RTSyntax, "zipped")
+	assert.NotNil(t, f)
+	data, err := f.Data()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("filetype: zipped\n"), data)
+	assert.NotNil(t, FindRuntimeFile(RTSyntax, "other"))
+	assert.NotNil(t, FindRuntimeFile(RTSyntax, "x"))
+	assert.Nil(t, FindRuntimeFile(RTSyntax, "readme"))
+
+	assert.Error(t, AddRuntimeFilesFromZip(RTSyntax, filepath.Join(t.TempDir(), "missing.zip"), "*.yaml"))
+}
+
+func TestGzipAsset(t *testing.T) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write([]byte("filetype: gzipped\n"))
+	assert.NoError(t, w.Close())
+
+	assets := map[string][]byte{"runtime/syntax/gzipped.yaml.gz": buf.Bytes()}
+	oldAsset, oldAssetDir := readAsset, readAssetDir
+	readAsset = func(name string) ([]byte, error) {
+		return assets[name], nil
+	}
+	readAssetDir = func(name string) ([]string, error) {
+		return []string{"gzipped.yaml.gz"}, nil
+	}
+	defer func() {
+		readAsset, readAssetDir = oldAsset, oldAssetDir
+		InitRuntimeFiles(false)
+	}()
+
+	AddRuntimeFilesFromAssets(RTSyntax, "runtime/syntax", "*.yaml")
+	f := FindRuntimeFile(RTSyntax, "gzipped")
+	assert.NotNil(t, f)
+	data, err := f.Data()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("filetype: gzipped\n"), data)
+}
+
+func TestAddFilesFromDirectoryRecursive(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) {
+		p := filepath.Join(dir, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		assert.NoError(t, os.WriteFile(p, []byte(data), 0644))
+	}
+	write("top.yaml", "top")
+	write("c-family/c.yaml", "c")
+	write("c-family/deep/cpp.yaml", "cpp")
+	write("c-family/deep/top.yaml", "deep top")
+	write("c-family/notes.txt", "notes")
+	defer InitRuntimeFiles(false)
+
+	assert.NoError(t, AddRuntimeFilesFromDirectoryRecursive(RTSyntax, dir, "*.yaml"))
+
+	for name, content := range map[string]string{"top": "top", "c": "c", "cpp": "cpp"} {
+		f := FindRuntimeFile(RTSyntax, name)
+		if assert.NotNil(t, f, name) {
+			data, err := f.Data()
+			assert.NoError(t, err)
+			assert.Equal(t, content, string(data))
+		}
+	}
+	assert.Nil(t, FindRuntimeFile(RTSyntax, "notes"))
+	assert.Equal(t, 3, len(ListRealRuntimeFiles(RTSyntax)))
+
+	// a missing directory is fine, a file is not a directory
+	assert.NoError(t, AddRuntimeFilesFromDirectoryRecursive(RTSyntax, filepath.Join(dir, "missing"), "*.yaml"))
+	assert.Error(t, AddRuntimeFilesFromDirectoryRecursive(RTSyntax, filepath.Join(dir, "top.yaml"), "*.yaml"))
+}
+
+func TestAddRealFileTwice(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "twice.yaml")
+	assert.NoError(t, os.WriteFile(p, []byte("filetype: twice\n"), 0644))
+	defer InitRuntimeFiles(false)
+
+	wd, err := os.Getwd()
+	assert.NoError(t, err)
+	rel, err := filepath.Rel(wd, p)
+	assert.NoError(t, err)
+
+	AddRealRuntimeFile(RTSyntax, realFile(p))
+	AddRealRuntimeFile(RTSyntax, realFile(rel))
+	AddRealRuntimeFile(RTSyntax, realFile(filepath.Join(dir, ".", "twice.yaml")))
+	AddRuntimeFilesFromDirectory(RTSyntax, dir, "*.yaml")
+
+	assert.Equal(t, 1, len(ListRealRuntimeFiles(RTSyntax)))
+	count := 0
+	for _, f := range ListRuntimeFiles(RTSyntax) {
+		if f.Name() == "twice" {
+			count++
+		}
+	}
+	assert.Equal(t, 1, count)
+}
+
+func TestInitRuntimeFilesErrors(t *testing.T) {
+	oldDir := ConfigDir
+	defer func() {
+		ConfigDir = oldDir
+		InitRuntimeFiles(false)
+	}()
+
+	// a config directory without runtime subdirectories is fine
+	ConfigDir = t.TempDir()
+	assert.NoError(t, InitRuntimeFiles(true))
+
+	// files in subdirectories of the runtime directories are loaded
+	nested := filepath.Join(ConfigDir, "syntax", "c-family")
+	assert.NoError(t, os.MkdirAll(nested, 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(nested, "nested.yaml"), []byte("filetype: nested\n"), 0644))
+	assert.NoError(t, InitRuntimeFiles(true))
+	assert.NotNil(t, FindRuntimeFile(RTSyntax, "nested"))
+
+	// a missing config directory is reported, but the assets are still loaded
+	ConfigDir = filepath.Join(t.TempDir(), "missing")
+	assert.Error(t, InitRuntimeFiles(true))
+	assert.NotNil(t, FindRuntimeFile(RTSyntax, "go"))
+
+	// so is a runtime directory that can't be read
+	ConfigDir = t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(ConfigDir, "syntax"), []byte("not a directory"), 0644))
+	assert.Error(t, InitRuntimeFiles(true))
+	assert.NotNil(t, FindRuntimeFile(RTSyntax, "go"))
+}
+
+func TestMemoryRuntimeFile(t *testing.T) {
+	defer InitRuntimeFiles(false)
+
+	AddRuntimeFile(RTSyntax, NewMemoryRuntimeFile("generated", []byte("filetype: generated\n")))
+
+	f := FindRuntimeFile(RTSyntax, "generated")
+	assert.NotNil(t, f)
+	assert.Equal(t, "generated", f.Name())
+	data, err := f.Data()
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("filetype: generated\n"), data)
+}
+
+func TestFindFileFold(t *testing.T) {
+	defer InitRuntimeFiles(false)
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "Go.yaml"), []byte("filetype: Go\n"), 0644))
+	AddRuntimeFilesFromDirectory(RTHelp, dir, "*.yaml")
+
+	f := FindRuntimeFileFold(RTHelp, "go")
+	assert.NotNil(t, f)
+	assert.Equal(t, "Go", f.Name())
+	assert.Nil(t, FindRuntimeFile(RTHelp, "go"))
+
+	assert.Equal(t, f, FindRuntimeFileFold(RTHelp, "go.yaml"))
+	assert.Equal(t, f, FindRuntimeFileFold(RTHelp, "GO.YAML"))
+	assert.Nil(t, FindRuntimeFileFold(RTHelp, "golang"))
+
+	// an exact match is preferred
+	AddRuntimeFile(RTHelp, memoryFile{"go", []byte("lower")})
+	f = FindRuntimeFileFold(RTHelp, "go")
+	assert.Equal(t, "go", f.Name())
+	f = FindRuntimeFileFold(RTHelp, "Go")
+	assert.Equal(t, "Go", f.Name())
+}
@@ -0,0 +1,98 @@
+package config
+
+import (
+	"context"
+	"io/fs"
+	"path/filepath"
+	"time"
+)
+
+// runtimeDirs are the directories of the config directory that hold
+// runtime files
+var runtimeDirs = []string{"syntax", "help", "colorschemes"}
+
+// watchInterval is how often WatchRuntimeFiles checks for changes
+var watchInterval = time.Second
+
+// watchDebounce is how long the runtime files must stay unchanged before
+// WatchRuntimeFiles reloads them
+var watchDebounce = 500 * time.Millisecond
+
+// fileStamp identifies a version of a file on disk
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// runtimeSnapshot returns the stamps of all files in the runtime directories
+// of the config directory and their subdirectories, which InitRuntimeFiles
+// loads too
+func runtimeSnapshot() map[string]fileStamp {
+	snap := make(map[string]fileStamp)
+	for _, dir := range runtimeDirs {
+		filepath.WalkDir(filepath.Join(ConfigDir, dir), func(p string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			snap[p] = fileStamp{info.ModTime(), info.Size()}
+			return nil
+		})
+	}
+	return snap
+}
+
+func sameSnapshot(a, b map[string]fileStamp) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, stamp := range a {
+		if b[name] != stamp {
+			return false
+		}
+	}
+	return true
+}
+
+// WatchRuntimeFiles polls the runtime directories of the config directory
+// until ctx is done. Successive changes are grouped together and once the
+// files have not changed for a while, WatchRuntimeFiles passes post a
+// function that reloads them with InitRuntimeFiles(true) and then calls
+// onChange with the error of the reload. The runtime files are read without
+// synchronization, so post must run the function on the goroutine that uses
+// them, normally by sending it to the main event loop. WatchRuntimeFiles
+// blocks, so it is normally run in its own goroutine
+func WatchRuntimeFiles(ctx context.Context, post func(func()), onChange func(error)) {
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+
+	reload := func() {
+		err := InitRuntimeFiles(true)
+		if onChange != nil {
+			onChange(err)
+		}
+	}
+
+	last := runtimeSnapshot()
+	var changed time.Time
+	pending := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			snap := runtimeSnapshot()
+			if !sameSnapshot(snap, last) {
+				last = snap
+				changed = now
+				pending = true
+			} else if pending && now.Sub(changed) >= watchDebounce {
+				pending = false
+				post(reload)
+			}
+		}
+	}
+}
@@ -27,15 +27,19 @@ var optionValidators = map[string]optionValidator{
 	"detectlimit":     validateNonNegativeValue,
 	"encoding":        validateEncoding,
 	"fileformat":      validateChoice,
+	"gutterwidth":     validateNonNegativeValue,
 	"helpsplit":       validateChoice,
+	"matchbracelimit": validateNonNegativeValue,
 	"matchbracestyle": validateChoice,
 	"multiopen":       validateChoice,
 	"pageoverlap":     validateNonNegativeValue,
+	"percentmode":     validateChoice,
 	"reload":          validateChoice,
 	"scrollmargin":    validateNonNegativeValue,
 	"scrollspeed":     validateNonNegativeValue,
 	"tabsize":         validatePositiveValue,
 	"truecolor":       validateChoice,
+	"undothreshold":   validateNonNegativeValue,
 }
 
 // a list of settings with pre-defined choices
@@ -45,6 +49,7 @@ var OptionChoices = map[string][]string{
 	"helpsplit":       {"hsplit", "vsplit"},
 	"matchbracestyle": {"underline", "highlight"},
 	"multiopen":       {"tab", "hsplit", "vsplit"},
+	"percentmode":     {"floor", "round", "ceil"},
 	"reload":          {"prompt", "auto", "disabled"},
 	"truecolor":       {"auto", "on", "off"},
 }
@@ -58,6 +63,7 @@ var defaultCommonSettings = map[string]interface{}{
 	"backupdir":       "",
 	"basename":        false,
 	"colorcolumn":     float64(0),
+	"commenttype":     "",
 	"cursorline":      true,
 	"detectlimit":     float64(100),
 	"diffgutter":      false,
@@ -66,6 +72,8 @@ var defaultCommonSettings = map[string]interface{}{
 	"fastdirty":       false,
 	"fileformat":      defaultFileFormat(),
 	"filetype":        "unknown",
+	"guttersep":       "",
+	"gutterwidth":     float64(0),
 	"hlsearch":        false,
 	"hltaberrors":     false,
 	"hltrailingws":    false,
@@ -75,9 +83,11 @@ var defaultCommonSettings = map[string]interface{}{
 	"keepautoindent":  false,
 	"matchbrace":      true,
 	"matchbraceleft":  true,
+	"matchbracelimit": float64(100000),
 	"matchbracestyle": "underline",
 	"mkparents":       false,
 	"pageoverlap":     float64(2),
+	"percentmode":     "floor",
 	"permbackup":      false,
 	"readonly":        false,
 	"relativeruler":   false,
@@ -89,10 +99,12 @@ var defaultCommonSettings = map[string]interface{}{
 	"scrollbar":       false,
 	"scrollmargin":    float64(3),
 	"scrollspeed":     float64(2),
+	"selectonundo":    false,
 	"smartpaste":      true,
 	"softwrap":        false,
 	"splitbottom":     true,
 	"splitright":      true,
+	"statusformatc":   "",
 	"statusformatl":   "$(filename) $(modified)$(overwrite)($(line),$(col)) $(status.paste)| ft:$(opt:filetype) | $(opt:fileformat) | $(opt:encoding)",
 	"statusformatr":   "$(bind:ToggleKeyMenu): bindings, $(bind:ToggleHelp): help",
 	"statusline":      true,
@@ -101,6 +113,8 @@ var defaultCommonSettings = map[string]interface{}{
 	"tabsize":         float64(4),
 	"tabstospaces":    false,
 	"truecolor":       "auto",
+	"undothreshold":   float64(1000),
+	"undotree":        false,
 	"useprimary":      true,
 	"wordwrap":        false,
 }
@@ -110,6 +124,7 @@ var defaultCommonSettings = map[string]interface{}{
 var DefaultGlobalOnlySettings = map[string]interface{}{
 	"autosave":       float64(0),
 	"clipboard":      "external",
+	"colorscheme":    "default",
 	"divchars":       "|-",
 	"divreverse":     true,
 	"fakecursor":     false,
@@ -122,8 +137,11 @@ var DefaultGlobalOnlySettings = map[string]interface{}{
 	"paste":          false,
 	"pluginchannels": []string{"https://raw.githubusercontent.com/micro-editor/plugin-channel/master/channel.json"},
 	"pluginrepos":    []string{},
+	"restoresession": false,
 	"savehistory":    true,
 	"scrollbarchar":  "|",
+	"settitle":       false,
+	"showcontrol":    false,
 	"sucmd":          "sudo",
 	"tabhighlight":   false,
 	"tabreverse":     true,
@@ -289,6 +307,9 @@ func InitGlobalSettings() error {
 			GlobalSettings[k] = v
 		}
 	}
+	if showcontrol, ok := GlobalSettings["showcontrol"].(bool); ok {
+		util.ShowControl = showcontrol
+	}
 	return err
 }
 
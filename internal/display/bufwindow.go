@@ -3,7 +3,6 @@ package display
 import (
 	"strconv"
 
-	runewidth "github.com/mattn/go-runewidth"
 	"github.com/micro-editor/tcell/v2"
 	"github.com/zyedidia/micro/v2/internal/buffer"
 	"github.com/zyedidia/micro/v2/internal/config"
@@ -25,6 +24,7 @@ type BufWindow struct {
 	bufWidth         int
 	bufHeight        int
 	gutterOffset     int
+	gutterSep        rune
 	hasMessage       bool
 	maxLineNumLength int
 	drawDivider      bool
@@ -119,7 +119,7 @@ func (w *BufWindow) updateDisplayInfo() {
 	b := w.Buf
 
 	w.drawDivider = false
-	if !b.Settings["statusline"].(bool) {
+	if !w.sline.Visible() {
 		_, h := screen.Screen.Size()
 		infoY := h
 		if config.GetGlobalOption("infobar").(bool) {
@@ -131,7 +131,7 @@ func (w *BufWindow) updateDisplayInfo() {
 	}
 
 	w.bufHeight = w.Height
-	if b.Settings["statusline"].(bool) || w.drawDivider {
+	if w.sline.Visible() || w.drawDivider {
 		w.bufHeight--
 	}
 
@@ -156,6 +156,15 @@ func (w *BufWindow) updateDisplayInfo() {
 	if b.Settings["ruler"].(bool) {
 		w.gutterOffset += w.maxLineNumLength + 1
 	}
+	if minWidth := util.IntOpt(b.Settings["gutterwidth"]); w.gutterOffset < minWidth {
+		w.gutterOffset = minWidth
+	}
+
+	w.gutterSep = 0
+	if sep := []rune(b.Settings["guttersep"].(string)); len(sep) > 0 && w.gutterOffset > 0 {
+		w.gutterSep = sep[0]
+		w.gutterOffset++
+	}
 
 	if w.gutterOffset > w.Width-scrollbarWidth {
 		w.gutterOffset = w.Width - scrollbarWidth
@@ -192,7 +201,7 @@ func (w *BufWindow) getStartInfo(n, lineN int) ([]byte, int, int, *tcell.Style)
 			ts := tabsize - (width % tabsize)
 			w = ts
 		default:
-			w = runewidth.RuneWidth(r)
+			w = util.RuneWidth(r)
 		}
 		if width+w > n {
 			return b, n - width, bloc.X, s
@@ -245,7 +254,7 @@ func (w *BufWindow) Relocate() bool {
 	// horizontal relocation (scrolling)
 	if !b.Settings["softwrap"].(bool) {
 		cx := activeC.GetVisualX(false)
-		rw := runewidth.RuneWidth(activeC.RuneUnder(activeC.X))
+		rw := util.RuneWidth(activeC.RuneUnder(activeC.X))
 		if rw == 0 {
 			rw = 1 // tab or newline
 		}
@@ -358,6 +367,28 @@ func (w *BufWindow) drawLineNum(lineNumStyle tcell.Style, softwrapped bool, vloc
 	}
 }
 
+// drawGutterEnd pads the gutter up to its minimum width and draws the
+// gutter separator
+func (w *BufWindow) drawGutterEnd(style tcell.Style, vloc *buffer.Loc) {
+	end := w.gutterOffset
+	if w.gutterSep != 0 {
+		end--
+	}
+	for vloc.X < end {
+		screen.SetContent(w.X+vloc.X, w.Y+vloc.Y, ' ', nil, style)
+		vloc.X++
+	}
+
+	if w.gutterSep != 0 && vloc.X < w.gutterOffset {
+		sepStyle := config.DefStyle
+		if s, ok := config.Colorscheme["divider"]; ok {
+			sepStyle = s
+		}
+		screen.SetContent(w.X+vloc.X, w.Y+vloc.Y, w.gutterSep, nil, sepStyle)
+		vloc.X++
+	}
+}
+
 // getStyle returns the highlight style for the given character position
 // If there is no change to the current highlight style it just returns that
 func (w *BufWindow) getStyle(style tcell.Style, bloc buffer.Loc) (tcell.Style, bool) {
@@ -478,6 +509,8 @@ func (w *BufWindow) displayBuffer() {
 			if b.Settings["ruler"].(bool) {
 				w.drawLineNum(s, false, &vloc, &bloc)
 			}
+
+			w.drawGutterEnd(s, &vloc)
 		} else {
 			vloc.X = w.gutterOffset
 		}
@@ -638,6 +671,8 @@ func (w *BufWindow) displayBuffer() {
 				if b.Settings["ruler"].(bool) {
 					w.drawLineNum(lineNumStyle, true, &vloc, &bloc)
 				}
+
+				w.drawGutterEnd(lineNumStyle, &vloc)
 			} else {
 				vloc.X = w.gutterOffset
 			}
@@ -678,7 +713,7 @@ func (w *BufWindow) displayBuffer() {
 				}
 				totalwidth += ts
 			default:
-				width = runewidth.RuneWidth(r)
+				width = util.RuneWidth(r)
 				totalwidth += width
 			}
 
@@ -712,6 +747,21 @@ func (w *BufWindow) displayBuffer() {
 			}
 
 			for _, r := range word {
+				if c, ok := util.CaretNotation(r.r); ok && util.ShowControl {
+					// Draw control characters in caret notation (e.g. ^M)
+					style := r.style
+					if s, ok := config.Colorscheme["special"]; ok {
+						fg, _, _ := s.Decompose()
+						style = style.Foreground(fg)
+					}
+					draw('^', nil, style, true, true)
+					if r.width > 1 {
+						draw(c, nil, style, true, false)
+					}
+					bloc.X++
+					continue
+				}
+
 				draw(r.r, r.combc, r.style, true, true)
 
 				// Draw any extra characters either spaces for tabs or @ for incomplete wide runes
@@ -779,8 +829,13 @@ func (w *BufWindow) displayBuffer() {
 	}
 }
 
+// StatusLine returns the statusline of the window
+func (w *BufWindow) StatusLine() *StatusLine {
+	return w.sline
+}
+
 func (w *BufWindow) displayStatusLine() {
-	if w.Buf.Settings["statusline"].(bool) {
+	if w.sline.Visible() {
 		w.sline.Display()
 	} else if w.drawDivider {
 		divchars := config.GetGlobalOption("divchars").(string)
@@ -839,6 +894,9 @@ func (w *BufWindow) displayScrollBar() {
 
 // Display displays the buffer and the statusline
 func (w *BufWindow) Display() {
+	config.UseFiletypeColorscheme(w.Buf.FileType())
+	defer config.UseGlobalColorscheme()
+
 	w.updateDisplayInfo()
 
 	w.displayStatusLine()
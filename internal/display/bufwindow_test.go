@@ -0,0 +1,88 @@
+package display
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zyedidia/micro/v2/internal/buffer"
+	"github.com/zyedidia/micro/v2/internal/config"
+	"github.com/zyedidia/micro/v2/internal/screen"
+	"github.com/zyedidia/micro/v2/internal/util"
+)
+
+func init() {
+	config.InitRuntimeFiles(false)
+	config.InitGlobalSettings()
+	config.GlobalSettings["backup"] = false
+	config.GlobalSettings["fastdirty"] = true
+	config.InitColorscheme()
+}
+
+func TestShowControl(t *testing.T) {
+	sim, err := screen.InitSimScreen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sim.Fini()
+
+	util.ShowControl = true
+	defer func() { util.ShowControl = false }()
+
+	b := buffer.NewBufferFromString("a\x01b", "", buffer.BTDefault)
+	defer b.Close()
+	b.Settings["ruler"] = false
+
+	w := NewBufWindow(0, 0, 20, 5, b)
+	w.Display()
+	sim.Show()
+
+	cells, width, _ := sim.GetContents()
+	var line []rune
+	for x := 0; x < 4; x++ {
+		line = append(line, cells[x].Runes[0])
+	}
+	assert.Equal(t, 80, width)
+	assert.Equal(t, "a^Ab", string(line))
+
+	// the cursor after the control character is two columns further
+	b.GetActiveCursor().X = 2
+	assert.Equal(t, 3, b.GetActiveCursor().GetVisualX(false))
+}
+
+func TestGutterSeparator(t *testing.T) {
+	sim, err := screen.InitSimScreen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sim.Fini()
+
+	b := buffer.NewBufferFromString("foo\nbar", "", buffer.BTDefault)
+	defer b.Close()
+	b.Settings["ruler"] = true
+	b.Settings["gutterwidth"] = float64(5)
+	b.Settings["guttersep"] = "|"
+
+	w := NewBufWindow(0, 0, 20, 5, b)
+	w.Display()
+	sim.Show()
+
+	cells, _, _ := sim.GetContents()
+	var line []rune
+	for x := 0; x < 9; x++ {
+		line = append(line, cells[x].Runes[0])
+	}
+	assert.Equal(t, "1    |foo", string(line))
+	assert.Equal(t, config.Colorscheme["divider"], cells[5].Style)
+
+	// the gutter grows to fit large line numbers
+	b.Settings["gutterwidth"] = float64(1)
+	w.Display()
+	sim.Show()
+
+	cells, _, _ = sim.GetContents()
+	line = line[:0]
+	for x := 0; x < 6; x++ {
+		line = append(line, cells[x].Runes[0])
+	}
+	assert.Equal(t, "1 |foo", string(line))
+}
@@ -1,7 +1,6 @@
 package display
 
 import (
-	runewidth "github.com/mattn/go-runewidth"
 	"github.com/zyedidia/micro/v2/internal/buffer"
 	"github.com/zyedidia/micro/v2/internal/util"
 )
@@ -103,7 +102,7 @@ func (w *BufWindow) getVLocFromLoc(loc buffer.Loc) VLoc {
 			}
 			totalwidth += ts
 		default:
-			width = runewidth.RuneWidth(r)
+			width = util.RuneWidth(r)
 			totalwidth += width
 		}
 
@@ -184,7 +183,7 @@ func (w *BufWindow) getLocFromVLoc(svloc VLoc) buffer.Loc {
 			}
 			totalwidth += ts
 		default:
-			width = runewidth.RuneWidth(r)
+			width = util.RuneWidth(r)
 			totalwidth += width
 		}
 
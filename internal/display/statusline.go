@@ -3,10 +3,17 @@ package display
 import (
 	"bytes"
 	"fmt"
+	"os"
+	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	runewidth "github.com/mattn/go-runewidth"
+	"github.com/micro-editor/tcell/v2"
 	"github.com/zyedidia/micro/v2/internal/buffer"
 	"github.com/zyedidia/micro/v2/internal/config"
 	"github.com/zyedidia/micro/v2/internal/screen"
@@ -18,9 +25,22 @@ import (
 // It gives information such as filename, whether the file has been
 // modified, filetype, cursor location
 type StatusLine struct {
+	// Info holds extra directives for this statusline only, which take
+	// precedence over the global ones
 	Info map[string]func(*buffer.Buffer) string
 
 	win *BufWindow
+	// segments are where the directives were drawn by the last Display
+	segments []statusSegment
+	// sugStart is the index of the first suggestion shown
+	sugStart int
+}
+
+// statusSegment is the range of window columns, end excluded, in which the
+// directive with the given name was drawn
+type statusSegment struct {
+	name       string
+	start, end int
 }
 
 var statusInfo = map[string]func(*buffer.Buffer) string{
@@ -33,6 +53,11 @@ var statusInfo = map[string]func(*buffer.Buffer) string{
 	"col": func(b *buffer.Buffer) string {
 		return strconv.Itoa(b.GetActiveCursor().X + 1)
 	},
+	"vcol": func(b *buffer.Buffer) string {
+		c := b.GetActiveCursor()
+		tabsize := util.IntOpt(b.Settings["tabsize"])
+		return strconv.Itoa(util.StringWidth(b.LineBytes(c.Y), c.X, tabsize) + 1)
+	},
 	"modified": func(b *buffer.Buffer) string {
 		if b.Modified() {
 			return "+ "
@@ -48,21 +73,299 @@ var statusInfo = map[string]func(*buffer.Buffer) string{
 		}
 		return ""
 	},
+	"flags": func(b *buffer.Buffer) string {
+		var flags []string
+		if b.Type.Readonly {
+			flags = append(flags, "ro")
+		}
+		if b.OverwriteMode {
+			flags = append(flags, "ovwr")
+		}
+		str := strings.Join(flags, " ")
+		if b.Modified() {
+			str = "+" + str
+		}
+		return str
+	},
 	"lines": func(b *buffer.Buffer) string {
 		return strconv.Itoa(b.LinesNum())
 	},
-	"percentage": func(b *buffer.Buffer) string {
-		return strconv.Itoa((b.GetActiveCursor().Y + 1) * 100 / b.LinesNum())
+	"encoding": func(b *buffer.Buffer) string {
+		return b.Encoding()
+	},
+	"selection": func(b *buffer.Buffer) string {
+		c := b.GetActiveCursor()
+		if !c.HasSelection() {
+			return ""
+		}
+		lines := c.CurSelection[1].Y - c.CurSelection[0].Y
+		if lines < 0 {
+			lines = -lines
+		}
+		return plural(lines+1, "line") + ", " + plural(util.CharacterCount(c.GetSelection()), "char")
+	},
+	"words": func(b *buffer.Buffer) string {
+		return strconv.Itoa(b.WordCount())
+	},
+	"cursors": func(b *buffer.Buffer) string {
+		if n := b.NumCursors(); n > 1 {
+			return strconv.Itoa(n) + " cursors"
+		}
+		return ""
+	},
+	"matches": func(b *buffer.Buffer) string {
+		if b.LastSearch == "" {
+			return ""
+		}
+		total, index := b.SearchMatches()
+		return strconv.Itoa(index) + "/" + strconv.Itoa(total)
+	},
+	"filesize": func(b *buffer.Buffer) string {
+		return humanSize(b.Size())
+	},
+	"offset": func(b *buffer.Buffer) string {
+		c := b.GetActiveCursor()
+		nl := 1
+		if b.Endings == buffer.FFDos {
+			nl = 2
+		}
+		offset := 0
+		for y := 0; y < c.Y; y++ {
+			offset += len(b.LineBytes(y)) + nl
+		}
+		line := b.LineBytes(c.Y)
+		for x := 0; x < c.X && len(line) > 0; x++ {
+			_, _, size := util.DecodeCharacter(line)
+			line = line[size:]
+			offset += size
+		}
+		return strconv.Itoa(offset)
+	},
+	"indent": func(b *buffer.Buffer) string {
+		kind := "tabs"
+		if spaces, _ := b.Settings["tabstospaces"].(bool); spaces {
+			kind = "spaces"
+		}
+		if size, ok := b.Settings["tabsize"].(float64); ok {
+			return kind + ":" + strconv.Itoa(int(size))
+		}
+		return kind
+	},
+	"lineending": func(b *buffer.Buffer) string {
+		if b.Endings == buffer.FFDos {
+			return "crlf"
+		}
+		return "lf"
+	},
+	"gitbranch": func(b *buffer.Buffer) string {
+		if b.Path == "" {
+			return ""
+		}
+		return cachedGitBranch(filepath.Dir(b.AbsPath))
+	},
+}
+
+// scrollSuggestions returns the index of the first suggestion to show in a
+// bar of the given width so that the current one is visible. The previous
+// start is kept if possible, so the bar only scrolls when the current
+// suggestion would leave it. Each suggestion takes its length plus a space
+func scrollSuggestions(suggestions []string, start, cur, width int) int {
+	if cur < 0 || cur >= len(suggestions) {
+		return util.Clamp(start, 0, len(suggestions)-1)
+	}
+	if cur < start {
+		return cur
+	}
+	// the space taken by the suggestions from start to cur
+	used := 0
+	for j := start; j <= cur; j++ {
+		used += utf8.RuneCountInString(suggestions[j]) + 1
+	}
+	// drop suggestions from the left until cur fits, but always show cur.
+	// The space after cur may be cut off
+	for used-1 > width && start < cur {
+		used -= utf8.RuneCountInString(suggestions[start]) + 1
+		start++
+	}
+	return start
+}
+
+// plural formats a count of things, adding an s to name unless n is 1
+func plural(n int, name string) string {
+	if n == 1 {
+		return "1 " + name
+	}
+	return strconv.Itoa(n) + " " + name + "s"
+}
+
+// RegisterStatusInfo adds a statusline directive that can be used as
+// $(name) in the statusformatl and statusformatr options of every buffer
+func RegisterStatusInfo(name string, fn func(*buffer.Buffer) string) {
+	statusInfo[name] = fn
+}
+
+// findBinding returns a key bound to the given action, or "null" if there
+// is none. The buffer keymap is searched first, then the other keymaps in
+// alphabetical order. Within a keymap the alphabetically first key is used
+func findBinding(action string) string {
+	keymaps := make([]string, 0, len(config.Bindings))
+	for name := range config.Bindings {
+		if name != "buffer" {
+			keymaps = append(keymaps, name)
+		}
+	}
+	sort.Strings(keymaps)
+	keymaps = append([]string{"buffer"}, keymaps...)
+
+	for _, name := range keymaps {
+		var keys []string
+		for k, v := range config.Bindings[name] {
+			if v == action {
+				keys = append(keys, k)
+			}
+		}
+		if len(keys) > 0 {
+			sort.Strings(keys)
+			return keys[0]
+		}
+	}
+	return "null"
+}
+
+// humanSize formats a number of bytes with a binary unit suffix, e.g. 1.2K
+func humanSize(n int) string {
+	if n < 1024 {
+		return strconv.Itoa(n) + "B"
+	}
+	size := float64(n)
+	unit := 0
+	// sizes that would be shown as 1024.0 of a unit use the next unit
+	for size >= 1023.95 && unit < len("KMGT") {
+		size /= 1024
+		unit++
+	}
+	return strconv.FormatFloat(size, 'f', 1, 64) + string("KMGT"[unit-1])
+}
+
+// gitBranchTTL is how long the result of a gitbranch lookup is reused
+var gitBranchTTL = 2 * time.Second
+
+type gitBranchEntry struct {
+	branch string
+	time   time.Time
+}
+
+// gitBranches caches gitBranch per directory, so that the filesystem is not
+// searched on every redraw
+var gitBranches = make(map[string]gitBranchEntry)
+
+func cachedGitBranch(dir string) string {
+	if e, ok := gitBranches[dir]; ok && time.Since(e.time) < gitBranchTTL {
+		return e.branch
+	}
+	branch := gitBranch(dir)
+	gitBranches[dir] = gitBranchEntry{branch, time.Now()}
+	return branch
+}
+
+// gitBranch returns the name of the branch checked out in the git repository
+// containing dir, the short commit hash if the HEAD is detached, or "" if dir
+// is not in a repository
+func gitBranch(dir string) string {
+	for {
+		gitDir := filepath.Join(dir, ".git")
+		if info, err := os.Stat(gitDir); err == nil {
+			if !info.IsDir() {
+				// worktrees and submodules have a .git file pointing to the
+				// actual git directory
+				data, err := os.ReadFile(gitDir)
+				if err != nil {
+					return ""
+				}
+				gitDir = strings.TrimSpace(strings.TrimPrefix(string(data), "gitdir:"))
+				if !filepath.IsAbs(gitDir) {
+					gitDir = filepath.Join(dir, gitDir)
+				}
+			}
+			head, err := os.ReadFile(filepath.Join(gitDir, "HEAD"))
+			if err != nil {
+				return ""
+			}
+			ref := strings.TrimSpace(string(head))
+			if strings.HasPrefix(ref, "ref:") {
+				ref = strings.TrimSpace(strings.TrimPrefix(ref, "ref:"))
+				return strings.TrimPrefix(ref, "refs/heads/")
+			}
+			return util.SliceStartStr(ref, 7)
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// winStatusInfo holds the statusline directives that also depend on the
+// window the buffer is displayed in
+var winStatusInfo = map[string]func(*BufWindow) string{
+	"percentage": func(w *BufWindow) string {
+		return percentage(w.Buf, w.bufHeight)
+	},
+	"percentline": func(w *BufWindow) string {
+		return roundedPercentage(w.Buf, w.bufHeight, "round")
 	},
 }
 
+// percentage returns how far the active cursor is through the buffer, as a
+// percentage rounded according to the percentmode option. Like less, it
+// returns "All" if the whole buffer fits in a window of the given height,
+// and "Top" or "Bot" if the cursor is on the first or last line
+func percentage(b *buffer.Buffer, height int) string {
+	return roundedPercentage(b, height, b.Settings["percentmode"].(string))
+}
+
+// roundedPercentage is percentage with the rounding given by mode, which is
+// one of the values of the percentmode option
+func roundedPercentage(b *buffer.Buffer, height int, mode string) string {
+	lines := b.LinesNum()
+	y := b.GetActiveCursor().Y
+	if lines <= height {
+		return "All"
+	} else if y == 0 {
+		return "Top"
+	} else if y == lines-1 {
+		return "Bot"
+	}
+
+	n := (y + 1) * 100
+	switch mode {
+	case "round":
+		return strconv.Itoa((n + lines/2) / lines)
+	case "ceil":
+		return strconv.Itoa((n + lines - 1) / lines)
+	default:
+		return strconv.Itoa(n / lines)
+	}
+}
+
 // NewStatusLine returns a statusline bound to a window
 func NewStatusLine(win *BufWindow) *StatusLine {
 	s := new(StatusLine)
+	s.Info = make(map[string]func(*buffer.Buffer) string)
 	s.win = win
 	return s
 }
 
+// Visible returns whether the statusline is shown, according to the
+// statusline option of the window's buffer. When it is hidden, the buffer
+// gets the last row of the window, unless a divider is drawn there instead
+func (s *StatusLine) Visible() bool {
+	visible, _ := s.win.Buf.Settings["statusline"].(bool)
+	return visible
+}
+
 // FindOpt finds a given option in the current buffer's settings
 func (s *StatusLine) FindOpt(opt string) interface{} {
 	if val, ok := s.win.Buf.Settings[opt]; ok {
@@ -89,11 +392,19 @@ func (s *StatusLine) Display() {
 		} else if style, ok := config.Colorscheme["statusline"]; ok {
 			statusLineStyle = style
 		}
+		activeStyle, ok := config.Colorscheme["statusline.suggestion.active"]
+		if !ok {
+			// setting reverse on an already reversed bar would not show
+			_, _, attrs := statusLineStyle.Decompose()
+			activeStyle = statusLineStyle.Reverse(attrs&tcell.AttrReverse == 0).Bold(true)
+		}
+		s.sugStart = scrollSuggestions(b.Suggestions, s.sugStart, b.CurSuggestion, s.win.Width)
 		x := 0
-		for j, sug := range b.Suggestions {
+		for j := s.sugStart; j < len(b.Suggestions); j++ {
+			sug := b.Suggestions[j]
 			style := statusLineStyle
 			if b.CurSuggestion == j {
-				style = style.Reverse(true)
+				style = activeStyle
 			}
 			for _, r := range sug {
 				screen.SetContent(winX+x, y, r, nil, style)
@@ -122,14 +433,14 @@ func (s *StatusLine) Display() {
 			option := name[4:]
 			return []byte(fmt.Sprint(s.FindOpt(string(option))))
 		} else if bytes.HasPrefix(name, []byte("bind")) {
-			binding := string(name[5:])
-			for k, v := range config.Bindings["buffer"] {
-				if v == binding {
-					return []byte(k)
-				}
-			}
-			return []byte("null")
+			return []byte(findBinding(string(name[5:])))
 		} else {
+			if fn, ok := s.Info[string(name)]; ok {
+				return []byte(fn(s.win.Buf))
+			}
+			if fn, ok := winStatusInfo[string(name)]; ok {
+				return []byte(fn(s.win))
+			}
 			if fn, ok := statusInfo[string(name)]; ok {
 				return []byte(fn(s.win.Buf))
 			}
@@ -137,10 +448,9 @@ func (s *StatusLine) Display() {
 		}
 	}
 
-	leftText := []byte(s.win.Buf.Settings["statusformatl"].(string))
-	leftText = formatParser.ReplaceAllFunc(leftText, formatter)
-	rightText := []byte(s.win.Buf.Settings["statusformatr"].(string))
-	rightText = formatParser.ReplaceAllFunc(rightText, formatter)
+	leftText, leftSegs := formatStatus(s.win.Buf.Settings["statusformatl"].(string), formatter)
+	centerText, centerSegs := formatStatus(s.win.Buf.Settings["statusformatc"].(string), formatter)
+	rightText, rightSegs := formatStatus(s.win.Buf.Settings["statusformatr"].(string), formatter)
 
 	statusLineStyle := config.DefStyle.Reverse(true)
 	if s.win.IsActive() {
@@ -156,37 +466,176 @@ func (s *StatusLine) Display() {
 	}
 
 	leftLen := util.StringWidth(leftText, util.CharacterCount(leftText), 1)
+	centerLen := util.StringWidth(centerText, util.CharacterCount(centerText), 1)
 	rightLen := util.StringWidth(rightText, util.CharacterCount(rightText), 1)
 
+	if avail := s.win.Width - rightLen; leftLen > avail && avail > 0 {
+		leftText = truncateMiddle(leftText, avail)
+		leftLen = util.StringWidth(leftText, util.CharacterCount(leftText), 1)
+		// the segments no longer match the truncated text
+		leftSegs = nil
+	}
+
 	for x := 0; x < s.win.Width; x++ {
-		if x < leftLen {
-			r, combc, size := util.DecodeCharacter(leftText)
-			leftText = leftText[size:]
-			rw := runewidth.RuneWidth(r)
-			for j := 0; j < rw; j++ {
-				c := r
-				if j > 0 {
-					c = ' '
-					combc = nil
-					x++
-				}
-				screen.SetContent(winX+x, y, c, combc, statusLineStyle)
+		screen.SetContent(winX+x, y, ' ', nil, statusLineStyle)
+	}
+
+	// The left text takes precedence, then the right text, and the center
+	// text gets whatever room is left between them
+	s.drawText(leftText, 0, s.win.Width, y, statusLineStyle)
+	rightX := s.win.Width - rightLen
+	if rightX < leftLen {
+		rightX = leftLen
+	}
+	s.drawText(rightText, rightX, s.win.Width, y, statusLineStyle)
+	x, maxX := centerSpan(leftLen, centerLen, rightX, s.win.Width)
+	s.drawText(centerText, x, maxX, y, statusLineStyle)
+
+	s.segments = s.segments[:0]
+	s.addSegments(leftSegs, 0, s.win.Width)
+	s.addSegments(rightSegs, rightX, s.win.Width)
+	s.addSegments(centerSegs, x, maxX)
+}
+
+// formatStatus expands the directives in a statusline format string with
+// formatter, and returns the resulting text and where in it, in columns,
+// each directive ended up
+func formatStatus(format string, formatter func([]byte) []byte) ([]byte, []statusSegment) {
+	src := []byte(format)
+	var text []byte
+	var segs []statusSegment
+	width := 0
+	last := 0
+	for _, m := range formatParser.FindAllIndex(src, -1) {
+		text = append(text, src[last:m[0]]...)
+		width += util.StringWidth(src[last:m[0]], util.CharacterCount(src[last:m[0]]), 1)
+
+		value := formatter(src[m[0]:m[1]])
+		w := util.StringWidth(value, util.CharacterCount(value), 1)
+		segs = append(segs, statusSegment{string(src[m[0]+2 : m[1]-1]), width, width + w})
+		text = append(text, value...)
+		width += w
+		last = m[1]
+	}
+	return append(text, src[last:]...), segs
+}
+
+// addSegments records segments drawn starting at column x, clipped to maxX
+func (s *StatusLine) addSegments(segs []statusSegment, x, maxX int) {
+	for _, seg := range segs {
+		seg.start += x
+		seg.end += x
+		if seg.end > maxX {
+			seg.end = maxX
+		}
+		if seg.start < seg.end {
+			s.segments = append(s.segments, seg)
+		}
+	}
+}
+
+// SegmentAt returns the name of the directive, such as "line" or
+// "opt:filetype", that the last Display drew at the given screen column, or
+// "" if the column shows plain text
+func (s *StatusLine) SegmentAt(x int) string {
+	x -= s.win.X
+	for _, seg := range s.segments {
+		if x >= seg.start && x < seg.end {
+			return seg.name
+		}
+	}
+	return ""
+}
+
+// truncateMiddle shortens text to fit in the given width by replacing its
+// middle with an ellipsis, keeping slightly more of the end than of the start
+func truncateMiddle(text []byte, width int) []byte {
+	const ellipsis = "..."
+
+	type char struct {
+		b []byte
+		w int
+	}
+	var chars []char
+	total := 0
+	for rest := text; len(rest) > 0; {
+		r, _, size := util.DecodeCharacter(rest)
+		chars = append(chars, char{rest[:size], runewidth.RuneWidth(r)})
+		total += runewidth.RuneWidth(r)
+		rest = rest[size:]
+	}
+	if total <= width {
+		return text
+	}
+
+	room := width - len(ellipsis)
+	if room < 2 {
+		// too narrow for an ellipsis, just cut the end off
+		var out []byte
+		for _, c := range chars {
+			if c.w > width {
+				break
 			}
-		} else if x >= s.win.Width-rightLen && x < rightLen+s.win.Width-rightLen {
-			r, combc, size := util.DecodeCharacter(rightText)
-			rightText = rightText[size:]
-			rw := runewidth.RuneWidth(r)
-			for j := 0; j < rw; j++ {
-				c := r
-				if j > 0 {
-					c = ' '
-					combc = nil
-					x++
-				}
-				screen.SetContent(winX+x, y, c, combc, statusLineStyle)
+			width -= c.w
+			out = append(out, c.b...)
+		}
+		return out
+	}
+
+	headWidth := room / 2
+	tailWidth := room - headWidth
+
+	var head []byte
+	for _, c := range chars {
+		if c.w > headWidth {
+			break
+		}
+		headWidth -= c.w
+		head = append(head, c.b...)
+	}
+	start := len(chars)
+	for start > 0 && chars[start-1].w <= tailWidth {
+		start--
+		tailWidth -= chars[start].w
+	}
+
+	out := append(head, ellipsis...)
+	for _, c := range chars[start:] {
+		out = append(out, c.b...)
+	}
+	return out
+}
+
+// centerSpan returns the columns between which text of width n is drawn to
+// be centered in a line of the given width, without going left of lo or
+// right of hi. If there is not enough room the span is narrower than n
+func centerSpan(lo, n, hi, width int) (int, int) {
+	if n > hi-lo {
+		return lo, hi
+	}
+	x := (width - n) / 2
+	x = util.Clamp(x, lo, hi-n)
+	return x, x + n
+}
+
+// drawText draws text on line y of the statusline starting at column x and
+// stopping before column maxX
+func (s *StatusLine) drawText(text []byte, x, maxX, y int, style tcell.Style) {
+	for len(text) > 0 {
+		r, combc, size := util.DecodeCharacter(text)
+		text = text[size:]
+		rw := runewidth.RuneWidth(r)
+		if x+rw > maxX {
+			return
+		}
+		for j := 0; j < rw; j++ {
+			c := r
+			if j > 0 {
+				c = ' '
+				combc = nil
 			}
-		} else {
-			screen.SetContent(winX+x, y, ' ', nil, statusLineStyle)
+			screen.SetContent(s.win.X+x, y, c, combc, style)
+			x++
 		}
 	}
 }
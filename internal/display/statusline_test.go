@@ -0,0 +1,646 @@
+package display
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/micro-editor/tcell/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/zyedidia/micro/v2/internal/buffer"
+	"github.com/zyedidia/micro/v2/internal/config"
+	"github.com/zyedidia/micro/v2/internal/screen"
+	"github.com/zyedidia/micro/v2/internal/util"
+)
+
+func TestPercentageLabels(t *testing.T) {
+	b := buffer.NewBufferFromString("a\nb\nc", "", buffer.BTDefault)
+	defer b.Close()
+	c := b.GetActiveCursor()
+
+	// the whole file fits in the window
+	for y := 0; y < 3; y++ {
+		c.Y = y
+		assert.Equal(t, "All", percentage(b, 10))
+	}
+
+	c.Y = 0
+	assert.Equal(t, "Top", percentage(b, 2))
+	c.Y = 1
+	assert.Equal(t, "66", percentage(b, 2))
+	c.Y = 2
+	assert.Equal(t, "Bot", percentage(b, 2))
+}
+
+func TestPercentageMode(t *testing.T) {
+	b := buffer.NewBufferFromString(strings.Repeat("\n", 299), "", buffer.BTDefault)
+	defer b.Close()
+	c := b.GetActiveCursor()
+	c.Y = 1
+
+	assert.Equal(t, "0", percentage(b, 10))
+	b.Settings["percentmode"] = "round"
+	assert.Equal(t, "1", percentage(b, 10))
+	b.Settings["percentmode"] = "ceil"
+	assert.Equal(t, "1", percentage(b, 10))
+
+	c.Y = 4
+	b.Settings["percentmode"] = "floor"
+	assert.Equal(t, "1", percentage(b, 10))
+	b.Settings["percentmode"] = "round"
+	assert.Equal(t, "2", percentage(b, 10))
+	b.Settings["percentmode"] = "ceil"
+	assert.Equal(t, "2", percentage(b, 10))
+}
+
+func TestPercentLine(t *testing.T) {
+	tests := []struct {
+		lines, y int
+		want     string
+	}{
+		{3, 0, "Top"},
+		{3, 1, "67"},
+		{3, 2, "Bot"},
+		{7, 1, "29"},
+		{7, 3, "57"},
+		{7, 5, "86"},
+		{200, 100, "51"},
+		{1000, 4, "1"},
+	}
+	for _, test := range tests {
+		b := buffer.NewBufferFromString(strings.Repeat("\n", test.lines-1), "", buffer.BTDefault)
+		b.GetActiveCursor().Y = test.y
+		// percentline ignores percentmode
+		b.Settings["percentmode"] = "floor"
+		w := NewBufWindow(0, 0, 20, 2, b)
+		assert.Equal(t, test.want, winStatusInfo["percentline"](w), "%d/%d", test.y, test.lines)
+		b.Close()
+	}
+}
+
+func TestEncodingInfo(t *testing.T) {
+	b := buffer.NewBufferFromString("plain", "", buffer.BTDefault)
+	defer b.Close()
+	assert.Equal(t, "utf-8", statusInfo["encoding"](b))
+
+	le := buffer.NewBufferFromString("\xff\xfeh\x00i\x00", "", buffer.BTDefault)
+	defer le.Close()
+	assert.Equal(t, "utf-16le", statusInfo["encoding"](le))
+	assert.Equal(t, "hi", string(le.Bytes()))
+
+	be := buffer.NewBufferFromString("\xfe\xff\x00h\x00i", "", buffer.BTDefault)
+	defer be.Close()
+	assert.Equal(t, "utf-16be", statusInfo["encoding"](be))
+	assert.Equal(t, "hi", string(be.Bytes()))
+
+	bom := buffer.NewBufferFromString("\xef\xbb\xbfhi", "", buffer.BTDefault)
+	defer bom.Close()
+	assert.Equal(t, "utf-8", statusInfo["encoding"](bom))
+	assert.Equal(t, "hi", string(bom.Bytes()))
+}
+
+func TestLineEndingInfo(t *testing.T) {
+	b := buffer.NewBufferFromString("a\nb\n", "", buffer.BTDefault)
+	defer b.Close()
+	assert.Equal(t, "lf", statusInfo["lineending"](b))
+
+	d := buffer.NewBufferFromString("a\r\nb\r\n", "", buffer.BTDefault)
+	defer d.Close()
+	assert.Equal(t, "crlf", statusInfo["lineending"](d))
+
+	// mixed endings are detected from the first line
+	m := buffer.NewBufferFromString("a\r\nb\nc\n", "", buffer.BTDefault)
+	defer m.Close()
+	assert.Equal(t, "crlf", statusInfo["lineending"](m))
+
+	m.SetOption("fileformat", "unix")
+	assert.Equal(t, "lf", statusInfo["lineending"](m))
+}
+
+func TestIndentInfo(t *testing.T) {
+	b := buffer.NewBufferFromString("", "", buffer.BTDefault)
+	defer b.Close()
+
+	b.Settings["tabstospaces"] = true
+	b.Settings["tabsize"] = float64(4)
+	assert.Equal(t, "spaces:4", statusInfo["indent"](b))
+
+	b.Settings["tabstospaces"] = false
+	b.Settings["tabsize"] = float64(8)
+	assert.Equal(t, "tabs:8", statusInfo["indent"](b))
+
+	delete(b.Settings, "tabstospaces")
+	delete(b.Settings, "tabsize")
+	assert.Equal(t, "tabs", statusInfo["indent"](b))
+}
+
+func TestFileSizeInfo(t *testing.T) {
+	tests := []struct {
+		size int
+		want string
+	}{
+		{0, "0B"},
+		{1023, "1023B"},
+		{1024, "1.0K"},
+		{1229, "1.2K"},
+		{1024*1024 - 1, "1.0M"},
+		{1024 * 1024, "1.0M"},
+		{3565158, "3.4M"},
+	}
+	for _, test := range tests {
+		b := buffer.NewBufferFromString(strings.Repeat("a", test.size), "", buffer.BTDefault)
+		assert.Equal(t, test.want, statusInfo["filesize"](b))
+		b.Close()
+	}
+
+	// unsaved edits are reflected
+	b := buffer.NewBufferFromString(strings.Repeat("a", 1000), "", buffer.BTDefault)
+	defer b.Close()
+	b.Insert(b.End(), strings.Repeat("\n", 100))
+	assert.Equal(t, "1.1K", statusInfo["filesize"](b))
+}
+
+func TestVisualColInfo(t *testing.T) {
+	b := buffer.NewBufferFromString("\ta\tb世c", "", buffer.BTDefault)
+	defer b.Close()
+	b.Settings["tabsize"] = float64(4)
+	c := b.GetActiveCursor()
+
+	tests := []struct {
+		x         int
+		col, vcol string
+	}{
+		{0, "1", "1"},
+		{1, "2", "5"},
+		{2, "3", "6"},
+		{3, "4", "9"},
+		{5, "6", "12"},
+	}
+	for _, test := range tests {
+		c.X = test.x
+		assert.Equal(t, test.col, statusInfo["col"](b))
+		assert.Equal(t, test.vcol, statusInfo["vcol"](b))
+	}
+
+	b.Settings["tabsize"] = float64(8)
+	c.X = 3
+	assert.Equal(t, "17", statusInfo["vcol"](b))
+}
+
+func TestFlagsInfo(t *testing.T) {
+	tests := []struct {
+		modified, readonly, overwrite bool
+		want                          string
+	}{
+		{false, false, false, ""},
+		{true, false, false, "+"},
+		{false, true, false, "ro"},
+		{false, false, true, "ovwr"},
+		{true, true, false, "+ro"},
+		{true, false, true, "+ovwr"},
+		{false, true, true, "ro ovwr"},
+		{true, true, true, "+ro ovwr"},
+	}
+	for _, test := range tests {
+		b := buffer.NewBufferFromString("text", "", buffer.BTDefault)
+		if test.modified {
+			b.Insert(b.Start(), "more ")
+		}
+		b.Type.Readonly = test.readonly
+		b.OverwriteMode = test.overwrite
+		assert.Equal(t, test.want, statusInfo["flags"](b))
+		b.Close()
+	}
+}
+
+func TestGitBranchInfo(t *testing.T) {
+	dir := t.TempDir()
+	head := filepath.Join(dir, ".git", "HEAD")
+	if err := os.MkdirAll(filepath.Join(dir, ".git"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(head, []byte("ref: refs/heads/feature/x\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	b := buffer.NewBufferFromString("", filepath.Join(dir, "src", "main.go"), buffer.BTDefault)
+	defer b.Close()
+	assert.Equal(t, "feature/x", statusInfo["gitbranch"](b))
+
+	// the branch is cached for a while
+	if err := os.WriteFile(head, []byte("0123456789abcdef0123456789abcdef01234567\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "feature/x", statusInfo["gitbranch"](b))
+	defer func(ttl time.Duration) { gitBranchTTL = ttl }(gitBranchTTL)
+	gitBranchTTL = 0
+	assert.Equal(t, "0123456", statusInfo["gitbranch"](b))
+
+	other := buffer.NewBufferFromString("", filepath.Join(t.TempDir(), "a.txt"), buffer.BTDefault)
+	defer other.Close()
+	assert.Equal(t, "", statusInfo["gitbranch"](other))
+
+	scratch := buffer.NewBufferFromString("", "", buffer.BTDefault)
+	defer scratch.Close()
+	assert.Equal(t, "", statusInfo["gitbranch"](scratch))
+}
+
+func TestOffsetInfo(t *testing.T) {
+	b := buffer.NewBufferFromString("héllo\nwörld\nfoo", "", buffer.BTDefault)
+	defer b.Close()
+	c := b.GetActiveCursor()
+
+	c.GotoLoc(buffer.Loc{X: 0, Y: 0})
+	assert.Equal(t, "0", statusInfo["offset"](b))
+	c.GotoLoc(buffer.Loc{X: 2, Y: 0})
+	assert.Equal(t, "3", statusInfo["offset"](b))
+	c.GotoLoc(buffer.Loc{X: 0, Y: 1})
+	assert.Equal(t, "7", statusInfo["offset"](b))
+	c.GotoLoc(buffer.Loc{X: 3, Y: 2})
+	assert.Equal(t, "17", statusInfo["offset"](b))
+
+	d := buffer.NewBufferFromString("a\r\nb", "", buffer.BTDefault)
+	defer d.Close()
+	d.GetActiveCursor().GotoLoc(buffer.Loc{X: 1, Y: 1})
+	assert.Equal(t, "4", statusInfo["offset"](d))
+}
+
+func TestSelectionInfo(t *testing.T) {
+	b := buffer.NewBufferFromString("héllo\nwörld\nfoo", "", buffer.BTDefault)
+	defer b.Close()
+	c := b.GetActiveCursor()
+	assert.Equal(t, "", statusInfo["selection"](b))
+
+	c.SetSelectionStart(buffer.Loc{X: 1, Y: 0})
+	c.SetSelectionEnd(buffer.Loc{X: 3, Y: 0})
+	assert.Equal(t, "1 line, 2 chars", statusInfo["selection"](b))
+
+	c.SetSelectionStart(buffer.Loc{X: 0, Y: 0})
+	c.SetSelectionEnd(buffer.Loc{X: 1, Y: 2})
+	assert.Equal(t, "3 lines, 13 chars", statusInfo["selection"](b))
+}
+
+func TestWordsInfo(t *testing.T) {
+	b := buffer.NewBufferFromString("  héllo wörld\n\n\tÆðelen  sæ fugol\n", "", buffer.BTDefault)
+	defer b.Close()
+	assert.Equal(t, "5", statusInfo["words"](b))
+
+	// the cached count is updated by edits
+	b.Insert(buffer.Loc{X: 0, Y: 1}, "über alles")
+	assert.Equal(t, "7", statusInfo["words"](b))
+	b.Remove(buffer.Loc{X: 0, Y: 0}, buffer.Loc{X: 0, Y: 2})
+	assert.Equal(t, "3", statusInfo["words"](b))
+	b.UndoOneEvent()
+	assert.Equal(t, "7", statusInfo["words"](b))
+}
+
+func TestRegisterStatusInfo(t *testing.T) {
+	sim, err := screen.InitSimScreen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sim.Fini()
+
+	RegisterStatusInfo("greeting", func(b *buffer.Buffer) string {
+		return "hi " + b.GetName()
+	})
+	defer delete(statusInfo, "greeting")
+
+	b := buffer.NewBufferFromString("foo", "", buffer.BTDefault)
+	defer b.Close()
+	b.SetName("buf")
+	b.Settings["statusformatl"] = "$(greeting)|$(lines)|$(filename)"
+	b.Settings["statusformatr"] = ""
+
+	w := NewBufWindow(0, 0, 20, 5, b)
+	// window-specific directives shadow the global ones
+	w.StatusLine().Info["filename"] = func(b *buffer.Buffer) string {
+		return "local"
+	}
+	w.Display()
+	sim.Show()
+
+	cells, width, _ := sim.GetContents()
+	var line []rune
+	for x := 0; x < 16; x++ {
+		line = append(line, cells[4*width+x].Runes[0])
+	}
+	assert.Equal(t, "hi buf|1|local  ", string(line))
+}
+
+func TestSegmentAt(t *testing.T) {
+	sim, err := screen.InitSimScreen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sim.Fini()
+
+	b := buffer.NewBufferFromString("foo\nbar", "", buffer.BTDefault)
+	defer b.Close()
+	b.SetName("file")
+	b.Settings["statusformatl"] = "$(filename) ($(line),$(col))"
+	b.Settings["statusformatc"] = ""
+	b.Settings["statusformatr"] = "$(opt:filetype)"
+
+	w := NewBufWindow(2, 0, 20, 5, b)
+	w.Display()
+	// file (1,1)    unknown
+	s := w.StatusLine()
+	assert.Equal(t, "filename", s.SegmentAt(2))
+	assert.Equal(t, "filename", s.SegmentAt(5))
+	assert.Equal(t, "", s.SegmentAt(6))
+	assert.Equal(t, "", s.SegmentAt(7))
+	assert.Equal(t, "line", s.SegmentAt(8))
+	assert.Equal(t, "", s.SegmentAt(9))
+	assert.Equal(t, "col", s.SegmentAt(10))
+	assert.Equal(t, "", s.SegmentAt(12))
+	assert.Equal(t, "opt:filetype", s.SegmentAt(15))
+	assert.Equal(t, "opt:filetype", s.SegmentAt(21))
+	assert.Equal(t, "", s.SegmentAt(22))
+	assert.Equal(t, "", s.SegmentAt(0))
+}
+
+func TestStatusLineVisible(t *testing.T) {
+	sim, err := screen.InitSimScreen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sim.Fini()
+	_, h := sim.Size()
+
+	b := buffer.NewBufferFromString("1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n14\n15\n16\n17\n18\n19\n20\n21\n22\n23\n24\n25\n26", "", buffer.BTDefault)
+	defer b.Close()
+	b.Settings["ruler"] = false
+	b.Settings["statusformatl"] = "STATUS"
+	b.Settings["statusformatr"] = ""
+
+	// the window reaches the infobar, so no divider replaces the statusline
+	w := NewBufWindow(0, 0, 20, h-1, b)
+	row := func() string {
+		sim.Show()
+		cells, width, _ := sim.GetContents()
+		var line []rune
+		for x := 0; x < 6; x++ {
+			line = append(line, cells[(h-2)*width+x].Runes[0])
+		}
+		return string(line)
+	}
+
+	w.Display()
+	assert.True(t, w.StatusLine().Visible())
+	assert.Equal(t, "STATUS", row())
+	assert.Equal(t, h-2, w.BufView().Height)
+
+	sim.Clear()
+	b.Settings["statusline"] = false
+	w.Display()
+	assert.False(t, w.StatusLine().Visible())
+	assert.Equal(t, strconv.Itoa(h-1)+"    ", row())
+	assert.Equal(t, h-1, w.BufView().Height)
+}
+
+func TestScrollSuggestions(t *testing.T) {
+	sugs := []string{"aaaa", "bbbb", "cccc", "dddd", "eeee"}
+
+	assert.Equal(t, 0, scrollSuggestions(sugs, 0, 1, 10))
+	// "aaaa bbbb cccc" does not fit in 14 columns without the last space cut
+	assert.Equal(t, 0, scrollSuggestions(sugs, 0, 2, 14))
+	assert.Equal(t, 1, scrollSuggestions(sugs, 0, 2, 13))
+	assert.Equal(t, 3, scrollSuggestions(sugs, 1, 4, 10))
+	// moving back keeps the start until the current one is off the left
+	assert.Equal(t, 3, scrollSuggestions(sugs, 3, 3, 10))
+	assert.Equal(t, 2, scrollSuggestions(sugs, 3, 2, 10))
+	// a suggestion wider than the bar is shown from its start
+	assert.Equal(t, 4, scrollSuggestions(sugs, 0, 4, 3))
+	assert.Equal(t, 2, scrollSuggestions(sugs, 2, -1, 10))
+}
+
+func TestSuggestionsScroll(t *testing.T) {
+	sim, err := screen.InitSimScreen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sim.Fini()
+	_, h := sim.Size()
+
+	b := buffer.NewBufferFromString("", "", buffer.BTDefault)
+	defer b.Close()
+	w := NewBufWindow(0, 0, 30, h-1, b)
+
+	for i := 0; i < 10; i++ {
+		b.Suggestions = append(b.Suggestions, "suggestion-"+strconv.Itoa(i))
+	}
+	b.HasSuggestions = true
+	row := func() string {
+		sim.Show()
+		cells, width, _ := sim.GetContents()
+		var line []rune
+		for x := 0; x < 30; x++ {
+			line = append(line, cells[(h-2)*width+x].Runes[0])
+		}
+		return string(line)
+	}
+
+	b.CurSuggestion = 0
+	w.Display()
+	assert.Equal(t, "suggestion-0 suggestion-1 sugg", row())
+
+	b.CurSuggestion = 7
+	w.Display()
+	assert.Equal(t, "suggestion-6 suggestion-7 sugg", row())
+
+	b.CurSuggestion = 8
+	w.Display()
+	assert.Equal(t, "suggestion-7 suggestion-8 sugg", row())
+
+	b.CurSuggestion = 7
+	w.Display()
+	assert.Equal(t, "suggestion-7 suggestion-8 sugg", row())
+
+	b.CurSuggestion = 9
+	w.Display()
+	assert.Equal(t, "suggestion-8 suggestion-9     ", row())
+}
+
+func TestActiveSuggestionStyle(t *testing.T) {
+	sim, err := screen.InitSimScreen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sim.Fini()
+	_, h := sim.Size()
+
+	b := buffer.NewBufferFromString("", "", buffer.BTDefault)
+	defer b.Close()
+	w := NewBufWindow(0, 0, 30, h-1, b)
+	b.Suggestions = []string{"one", "two", "three"}
+	b.HasSuggestions = true
+	b.CurSuggestion = 1
+
+	styles := func() (tcell.Style, tcell.Style) {
+		w.Display()
+		sim.Show()
+		cells, width, _ := sim.GetContents()
+		return cells[(h-2)*width].Style, cells[(h-2)*width+4].Style
+	}
+
+	defer config.SetColorscheme("default")
+	for _, scheme := range []string{"default-dark", "default-light", "high-contrast"} {
+		assert.NoError(t, config.SetColorscheme(scheme))
+		inactive, active := styles()
+		assert.Equal(t, config.Colorscheme["statusline"], inactive)
+		assert.Equal(t, config.Colorscheme["statusline.suggestion.active"], active)
+		assert.NotEqual(t, inactive, active)
+	}
+
+	// without the group the active suggestion is still distinct
+	delete(config.Colorscheme, "statusline.suggestion.active")
+	inactive, active := styles()
+	assert.NotEqual(t, inactive, active)
+	_, _, attrs := active.Decompose()
+	assert.Equal(t, tcell.AttrMask(0), attrs&tcell.AttrReverse)
+}
+
+func TestCenterSegment(t *testing.T) {
+	sim, err := screen.InitSimScreen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sim.Fini()
+
+	b := buffer.NewBufferFromString("foo", "", buffer.BTDefault)
+	defer b.Close()
+	b.Settings["statusformatl"] = "LEFT"
+	b.Settings["statusformatc"] = "mid"
+	b.Settings["statusformatr"] = "RIGHT"
+
+	tests := []struct {
+		width int
+		line  string
+	}{
+		{20, "LEFT    mid    RIGHT"},
+		{13, "LEFT midRIGHT"},
+		{12, "LEFTmidRIGHT"},
+		{10, "LEFTmRIGHT"},
+		{9, "LEFTRIGHT"},
+		{6, "LRIGHT"},
+	}
+	for _, tt := range tests {
+		sim.Clear()
+		w := NewBufWindow(0, 0, tt.width, 5, b)
+		w.Display()
+		sim.Show()
+
+		cells, width, _ := sim.GetContents()
+		var line []rune
+		for x := 0; x < tt.width; x++ {
+			line = append(line, cells[4*width+x].Runes[0])
+		}
+		assert.Equal(t, tt.line, string(line), "width %d", tt.width)
+	}
+}
+
+func TestTruncateMiddle(t *testing.T) {
+	path := []byte("/home/user/project/file.go")
+	assert.Equal(t, string(path), string(truncateMiddle(path, 30)))
+	assert.Equal(t, string(path), string(truncateMiddle(path, 26)))
+	assert.Equal(t, "/home/u.../file.go", string(truncateMiddle(path, 18)))
+	assert.Equal(t, "/h...go", string(truncateMiddle(path, 7)))
+	assert.Equal(t, "/ho", string(truncateMiddle(path, 3)))
+
+	// wide characters are never split
+	wide := []byte("日本語のファイル名.txt")
+	out := truncateMiddle(wide, 12)
+	assert.Equal(t, "日本....txt", string(out))
+	assert.True(t, util.StringWidth(out, util.CharacterCount(out), 1) <= 12)
+}
+
+func TestTruncateLeftText(t *testing.T) {
+	sim, err := screen.InitSimScreen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sim.Fini()
+
+	b := buffer.NewBufferFromString("foo", "", buffer.BTDefault)
+	defer b.Close()
+	b.SetName("/home/user/project/file.go")
+	b.Settings["statusformatl"] = "$(filename)"
+	b.Settings["statusformatr"] = "(1,1)"
+
+	render := func(width int) string {
+		sim.Clear()
+		w := NewBufWindow(0, 0, width, 5, b)
+		w.Display()
+		sim.Show()
+		cells, sw, _ := sim.GetContents()
+		var line []rune
+		for x := 0; x < width; x++ {
+			line = append(line, cells[4*sw+x].Runes[0])
+		}
+		return string(line)
+	}
+
+	assert.Equal(t, "/home/user/project/file.go   (1,1)", render(34))
+	assert.Equal(t, "/home/user/project/file.go(1,1)", render(31))
+	assert.Equal(t, "/home/use...ct/file.go(1,1)", render(27))
+}
+
+func TestCursorsInfo(t *testing.T) {
+	b := buffer.NewBufferFromString("foo\nbar\nbaz", "", buffer.BTDefault)
+	defer b.Close()
+	assert.Equal(t, "", statusInfo["cursors"](b))
+
+	b.AddCursor(buffer.NewCursor(b, buffer.Loc{X: 0, Y: 1}))
+	b.AddCursor(buffer.NewCursor(b, buffer.Loc{X: 0, Y: 2}))
+	assert.Equal(t, "3 cursors", statusInfo["cursors"](b))
+
+	b.ClearCursors()
+	assert.Equal(t, "", statusInfo["cursors"](b))
+}
+
+func TestMatchesInfo(t *testing.T) {
+	b := buffer.NewBufferFromString("foo bar\nfoo\nbaz foo foo", "", buffer.BTDefault)
+	defer b.Close()
+	c := b.GetActiveCursor()
+	assert.Equal(t, "", statusInfo["matches"](b))
+
+	b.LastSearch = "foo"
+	assert.Equal(t, "1/4", statusInfo["matches"](b))
+
+	// a search selects the match it found
+	m, found, err := b.FindNext("foo", b.Start(), b.End(), buffer.Loc{X: 1, Y: 1}, true, false)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	c.SetSelectionStart(m[0])
+	c.SetSelectionEnd(m[1])
+	c.Loc = m[1]
+	assert.Equal(t, "3/4", statusInfo["matches"](b))
+
+	// the count follows edits
+	b.Insert(buffer.Loc{X: 0, Y: 0}, "foo ")
+	assert.Equal(t, "4/5", statusInfo["matches"](b))
+
+	b.LastSearch = "nothing"
+	assert.Equal(t, "0/0", statusInfo["matches"](b))
+}
+
+func TestFindBinding(t *testing.T) {
+	config.Bindings["command"]["Ctrl-x"] = "TestAction"
+	config.Bindings["terminal"]["Ctrl-a"] = "TestAction"
+	defer delete(config.Bindings["command"], "Ctrl-x")
+	defer delete(config.Bindings["terminal"], "Ctrl-a")
+	assert.Equal(t, "Ctrl-x", findBinding("TestAction"))
+
+	// the buffer keymap takes precedence, then the first key in order
+	config.Bindings["buffer"]["Ctrl-q"] = "TestAction"
+	config.Bindings["buffer"]["Alt-q"] = "TestAction"
+	defer delete(config.Bindings["buffer"], "Ctrl-q")
+	defer delete(config.Bindings["buffer"], "Alt-q")
+	assert.Equal(t, "Alt-q", findBinding("TestAction"))
+
+	assert.Equal(t, "null", findBinding("UnboundAction"))
+}
@@ -74,14 +74,14 @@ func (w *TermWindow) Display() {
 			l.X, l.Y = x, y
 			c, f, b := w.State.Cell(x, y)
 
-			fg, bg := int(f), int(b)
-			if f == terminal.DefaultFG {
-				fg = int(tcell.ColorDefault)
+			fg, bg := tcell.ColorDefault, tcell.ColorDefault
+			if f != terminal.DefaultFG {
+				fg = config.GetColor256(int(f))
 			}
-			if b == terminal.DefaultBG {
-				bg = int(tcell.ColorDefault)
+			if b != terminal.DefaultBG {
+				bg = config.GetColor256(int(b))
 			}
-			st := tcell.StyleDefault.Foreground(config.GetColor256(fg)).Background(config.GetColor256(bg))
+			st := tcell.StyleDefault.Foreground(fg).Background(bg)
 
 			if l.LessThan(w.Selection[1]) && l.GreaterEqual(w.Selection[0]) || l.LessThan(w.Selection[0]) && l.GreaterEqual(w.Selection[1]) {
 				st = st.Reverse(true)
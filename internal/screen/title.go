@@ -0,0 +1,65 @@
+package screen
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	isatty "github.com/mattn/go-isatty"
+	"github.com/micro-editor/tcell/v2"
+)
+
+// curTitle is the title micro last set, and titleSaved is true once the
+// original terminal title has been pushed onto the terminal's title stack
+var curTitle string
+var titleSaved bool
+
+// titleSupported returns whether title escape sequences can be written,
+// which is only the case for a real terminal on stdout
+func titleSupported() bool {
+	if Screen == nil {
+		return false
+	}
+	if _, ok := Screen.(tcell.SimulationScreen); ok {
+		return false
+	}
+	return isatty.IsTerminal(os.Stdout.Fd())
+}
+
+// SetTitle sets the terminal window title with an OSC 2 escape sequence.
+// The first time it is called the original title is saved so that
+// RestoreTitle can bring it back. Terminals that don't support these
+// sequences simply ignore them
+func SetTitle(title string) {
+	title = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, title)
+
+	if title == curTitle || !titleSupported() {
+		return
+	}
+
+	if !titleSaved {
+		// push the current title onto the title stack
+		fmt.Fprint(os.Stdout, "\x1b[22;0t")
+		titleSaved = true
+	}
+	fmt.Fprintf(os.Stdout, "\x1b]2;%s\x07", title)
+	curTitle = title
+}
+
+// RestoreTitle restores the terminal title that was active before the
+// first call to SetTitle
+func RestoreTitle() {
+	if !titleSaved {
+		return
+	}
+
+	// pop the original title from the title stack
+	fmt.Fprint(os.Stdout, "\x1b[23;0t")
+	titleSaved = false
+	curTitle = ""
+}
@@ -31,6 +31,38 @@ func ExecCommand(name string, arg ...string) (string, error) {
 	return outstring, err
 }
 
+// PipeCommand executes a command using exec, streaming input to its
+// standard input. It returns the command's standard output, or an error
+// containing its standard error if the command fails
+func PipeCommand(input io.Reader, name string, arg ...string) (string, error) {
+	cmd := exec.Command(name, arg...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return "", err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return "", err
+	}
+	go func() {
+		io.Copy(stdin, input)
+		stdin.Close()
+	}()
+
+	err = cmd.Wait()
+	if err != nil {
+		if msg := bytes.TrimSpace(stderr.Bytes()); len(msg) > 0 {
+			return "", fmt.Errorf("%s: %v: %s", name, err, msg)
+		}
+		return "", fmt.Errorf("%s: %v", name, err)
+	}
+	return stdout.String(), nil
+}
+
 // RunCommand executes a shell command and returns the output/error
 func RunCommand(input string) (string, error) {
 	args, err := shellquote.Split(input)
@@ -0,0 +1,28 @@
+package shell
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPipeCommand(t *testing.T) {
+	out, err := PipeCommand(strings.NewReader("hello, world\n"), "tr", "a-z", "A-Z")
+	assert.NoError(t, err)
+	assert.Equal(t, "HELLO, WORLD\n", out)
+}
+
+func TestPipeCommandLargeInput(t *testing.T) {
+	input := strings.Repeat("abcdefgh\n", 100000)
+	out, err := PipeCommand(strings.NewReader(input), "tr", "a-z", "A-Z")
+	assert.NoError(t, err)
+	assert.Equal(t, strings.ToUpper(input), out)
+}
+
+func TestPipeCommandError(t *testing.T) {
+	_, err := PipeCommand(strings.NewReader(""), "sh", "-c", "echo oops >&2; exit 3")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "oops")
+	}
+}
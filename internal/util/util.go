@@ -34,6 +34,9 @@ var (
 	// FakeCursor is used to disable the terminal cursor and have micro
 	// draw its own (enabled for windows consoles where the cursor is slow)
 	FakeCursor = false
+	// ShowControl makes control characters display in caret notation
+	// (e.g. ^M), two columns wide
+	ShowControl = false
 
 	// Stdout is a buffer that is written to stdout when micro closes
 	Stdout *bytes.Buffer
@@ -175,7 +178,7 @@ func SliceVisualEnd(b []byte, n, tabsize int) ([]byte, int, int) {
 			ts := tabsize - (width % tabsize)
 			w = ts
 		default:
-			w = runewidth.RuneWidth(r)
+			w = RuneWidth(r)
 		}
 		if width+w > n {
 			return b, n - width, i
@@ -187,6 +190,30 @@ func SliceVisualEnd(b []byte, n, tabsize int) ([]byte, int, int) {
 	return b, n - width, i
 }
 
+// CaretNotation returns the character to display after a '^' for the given
+// control character (e.g. 'M' for '\r'), and false if r is not a control
+// character. Tabs are not considered control characters here
+func CaretNotation(r rune) (rune, bool) {
+	if r == '\t' {
+		return 0, false
+	}
+	if r >= 0 && r < 0x20 || r == 0x7f {
+		return r ^ 0x40, true
+	}
+	return 0, false
+}
+
+// RuneWidth returns the visual width of a rune other than a tab. Control
+// characters are two columns wide if ShowControl is enabled
+func RuneWidth(r rune) int {
+	if ShowControl {
+		if _, ok := CaretNotation(r); ok {
+			return 2
+		}
+	}
+	return runewidth.RuneWidth(r)
+}
+
 // Abs is a simple absolute value function for ints
 func Abs(n int) int {
 	if n < 0 {
@@ -212,7 +239,7 @@ func StringWidth(b []byte, n, tabsize int) int {
 			ts := tabsize - (width % tabsize)
 			width += ts
 		default:
-			width += runewidth.RuneWidth(r)
+			width += RuneWidth(r)
 		}
 
 		i++
@@ -504,7 +531,7 @@ func GetCharPosInLine(b []byte, visualPos int, tabsize int) int {
 			ts := tabsize - (width % tabsize)
 			width += ts
 		default:
-			width += runewidth.RuneWidth(r)
+			width += RuneWidth(r)
 		}
 
 		if width >= visualPos {